@@ -1,12 +1,21 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/mitlibraries/mario/pkg/client"
+	"github.com/mitlibraries/mario/pkg/generator"
 	"github.com/mitlibraries/mario/pkg/ingester"
 	"github.com/urfave/cli"
+	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 )
 
 func main() {
@@ -40,13 +49,12 @@ func main() {
 	app.Commands = []cli.Command{
 		{
 			Name:      "ingest",
-			Usage:     "Parse and ingest the input file",
-			ArgsUsage: "[filepath, use format 's3://bucketname/objectname' for s3]",
+			Usage:     "Parse and ingest the input files",
+			ArgsUsage: "[filepath, glob or directory, use format 's3://bucketname/objectname' or 's3://bucketname/prefix/' for s3]",
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:  "rules",
-					Value: "/config/marc_rules.json",
-					Usage: "Path to marc rules file",
+					Usage: "Path to marc rules file: default is the source's rules",
 				},
 				cli.StringFlag{
 					Name:  "consumer, c",
@@ -55,13 +63,15 @@ func main() {
 				},
 				cli.StringFlag{
 					Name:  "type, t",
-					Value: "marc",
-					Usage: "Type of file to process",
+					Usage: "Type of file to process, one of marc, marcxml, mrk, marcjson, archives, dc, mods, json or oai to harvest from an OAI-PMH base URL: default is worked out from the file's source",
 				},
 				cli.StringFlag{
 					Name:  "prefix, p",
-					Value: "aleph",
-					Usage: "Index prefix to use: default is aleph",
+					Usage: "Index prefix to use: default is the file's source, or the source for its type of data, which must be given for mods, json and OAI-PMH formats without a source",
+				},
+				cli.StringFlag{
+					Name:  "data-root",
+					Usage: "Local directory laid out like the source data bucket, so that files under it are routed by environment and source",
 				},
 				cli.BoolFlag{
 					Name:        "debug",
@@ -73,40 +83,152 @@ func main() {
 					Usage:       "Automatically promote / demote on completion",
 					Destination: &auto,
 				},
+				cli.BoolFlag{
+					Name:  "fail-fast",
+					Usage: "Stop the ingest at the first record that cannot be processed",
+				},
+				cli.StringFlag{
+					Name:  "rejects",
+					Usage: "Write rejected records to this file or 's3://bucketname/objectname', with reasons in a .jsonl file next to it",
+				},
+				cli.IntFlag{
+					Name:  "workers, w",
+					Value: 1,
+					Usage: "Number of workers used to map MARC records and run transformers",
+				},
+				cli.BoolFlag{
+					Name:  "ordered",
+					Usage: "Keep records in input order when using more than one worker",
+				},
+				cli.DurationFlag{
+					Name:  "timeout",
+					Usage: "Stop ingesting after this long, for example 11h30m",
+				},
+				cli.StringFlag{
+					Name:  "checkpoint",
+					Usage: "Save progress to this file or 's3://bucketname/objectname' so the ingest can be resumed",
+				},
+				cli.DurationFlag{
+					Name:  "checkpoint-interval",
+					Value: time.Minute,
+					Usage: "How often to save a checkpoint",
+				},
+				cli.BoolFlag{
+					Name:  "resume",
+					Usage: "Resume the ingest from the checkpoint, skipping records already indexed",
+				},
+				cli.StringFlag{
+					Name:  "report",
+					Usage: "Write a JSON report of the run to this file or 's3://bucketname/objectname', or 'stdout' with the es consumer",
+				},
+				cli.StringFlag{
+					Name:  "alias",
+					Usage: "Primary alias to use: default is timdex-<env> for the environment in the file path, or timdex-prod",
+				},
+				sourceAliasesFlag,
+				cli.Float64Flag{
+					Name:  "gate-min-ratio",
+					Value: 0.9,
+					Usage: "With --auto, only promote if the new index has at least this share of the documents in the index it replaces, or 0 to skip the check",
+				},
+				cli.StringSliceFlag{
+					Name:  "gate-query",
+					Usage: "With --auto, only promote if this query string query matches a document in the new index. Can be repeated",
+				},
+				cli.StringFlag{
+					Name:  "metadata-prefix",
+					Value: "oai_ead",
+					Usage: "With --type oai, metadata format to harvest: oai_ead, oai_dc, qdc, mods, marc21 or marcxml",
+				},
+				cli.StringFlag{
+					Name:  "set",
+					Usage: "With --type oai, set to harvest",
+				},
+				cli.StringFlag{
+					Name:  "from",
+					Usage: "With --type oai, only harvest records changed on or after this date",
+				},
+				cli.StringFlag{
+					Name:  "until",
+					Usage: "With --type oai, only harvest records changed on or before this date",
+				},
 			},
 			Action: func(c *cli.Context) error {
-				var es *client.ESClient
+				var indexer client.Indexer
+				ctx, cancel := interruptible(c.Duration("timeout"))
+				defer cancel()
 				config := ingester.Config{
-					Filename:  c.Args().Get(0),
-					Consumer:  c.String("consumer"),
-					Source:    c.String("type"),
-					Index:     index,
-					Prefix:    c.String("prefix"),
-					Promote:   auto,
-					Rulesfile: c.String("rules"),
+					Filename:           c.Args().Get(0),
+					DataRoot:           c.String("data-root"),
+					Consumer:           c.String("consumer"),
+					Source:             c.String("type"),
+					Index:              index,
+					Prefix:             c.String("prefix"),
+					Promote:            auto,
+					Rulesfile:          c.String("rules"),
+					FailFast:           c.Bool("fail-fast"),
+					Workers:            c.Int("workers"),
+					Ordered:            c.Bool("ordered"),
+					Rejects:            c.String("rejects"),
+					Checkpoint:         c.String("checkpoint"),
+					CheckpointInterval: c.Duration("checkpoint-interval"),
+					Resume:             c.Bool("resume"),
+					Alias:              c.String("alias"),
+					Gate: ingester.Gate{
+						MinRatio: c.Float64("gate-min-ratio"),
+						Queries:  c.StringSlice("gate-query"),
+					},
+					Harvest: generator.Harvest{
+						MetadataPrefix: c.String("metadata-prefix"),
+						Set:            c.String("set"),
+						From:           c.String("from"),
+						Until:          c.String("until"),
+					},
 				}
-				stream, err := ingester.NewStream(config.Filename)
-				if err != nil {
+				// The other consumers write records to stdout, which the
+				// report would be mixed into.
+				if c.String("report") == "stdout" && config.Consumer != "es" {
+					return errors.New("The report can only be written to stdout with the es consumer")
+				}
+				err := config.Route()
+				if errors.Is(err, ingester.ErrUnknownSource) {
+					return exitStatus(fmt.Errorf("Not ingesting file, give --prefix to ingest it anyway: %w", err))
+				} else if err != nil {
 					return err
 				}
-				defer stream.Close()
 				if config.Consumer == "es" {
-					es, err = client.NewESClient(url, v4)
+					es, err := client.NewESClient(url, v4)
+					if err != nil {
+						return err
+					}
+					es.Alias = config.Alias
+					es.SourceAliases = c.Bool("source-aliases")
+					indexer, err = es.Detect(url)
 					if err != nil {
 						return err
 					}
 				}
 
-				ingest := ingester.Ingester{Stream: stream, Client: es}
+				ingest := ingester.Ingester{Client: indexer}
 				err = ingest.Configure(config)
 				if err != nil {
 					return err
 				}
-				count, err := ingest.Ingest()
+				report, err := ingest.Ingest(ctx)
+				// Logged rather than printed, so that a report written to
+				// stdout can still be parsed.
 				if debug {
-					fmt.Printf("Total records ingested: %d\n", count)
+					for _, f := range report.Files {
+						log.Printf("%s: %d records ingested", f.Filename, f.Indexed)
+					}
+					log.Printf("Total records ingested: %d", report.Indexed)
 				}
-				return err
+				if path := c.String("report"); path != "" {
+					if rerr := report.Write(path); rerr != nil {
+						log.Println("Could not write report:", rerr)
+					}
+				}
+				return exitStatus(err)
 			},
 		},
 		{
@@ -134,6 +256,81 @@ Name: %s
 				return nil
 			},
 		},
+		{
+			Name:  "stats",
+			Usage: "Show document counts by facet and field coverage for an Elasticsearch index",
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "size",
+					Value: 10,
+					Usage: "Number of values to show for each facet",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				es, err := client.NewESClient(url, v4)
+				if err != nil {
+					return err
+				}
+				cluster, err := es.Detect(url)
+				if err != nil {
+					return err
+				}
+				stats, err := cluster.Stats(index, c.Int("size"))
+				if err != nil {
+					return err
+				}
+				fmt.Printf("\nIndex: %s\n  Documents: %d\n", stats.Index, stats.Total)
+				for _, f := range client.StatsFacets {
+					fmt.Printf("\n%s:\n", f)
+					for _, b := range stats.Facets[f] {
+						fmt.Printf("  %-40s %d\n", b.Value, b.Count)
+					}
+				}
+				fmt.Printf("\nField coverage:\n")
+				for _, f := range stats.Coverage {
+					fmt.Printf("  %-40s %10d %6.1f%%\n", f.Field, f.Count, f.Share*100)
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "mappings",
+			Usage: "Compare Elasticsearch index mappings with the mappings config",
+			Subcommands: []cli.Command{
+				{
+					Name:  "diff",
+					Usage: "Show where an index's mappings and analysis settings differ from the config",
+					Action: func(c *cli.Context) error {
+						es, err := client.NewESClient(url, v4)
+						if err != nil {
+							return err
+						}
+						cluster, err := es.Detect(url)
+						if err != nil {
+							return err
+						}
+						diff, err := cluster.DiffMappings(index)
+						if err != nil {
+							return err
+						}
+						fmt.Printf("\nIndex: %s\n", diff.Index)
+						for _, d := range append(diff.Mappings, diff.Settings...) {
+							fmt.Printf("  %s\n    config: %v\n    index:  %v\n", d.Path, d.Expected, d.Actual)
+						}
+						if len(diff.Mappings) == 0 && len(diff.Settings) == 0 {
+							fmt.Println("  Mappings and settings match the config")
+						}
+						if len(diff.Unmapped) > 0 {
+							fmt.Printf("\nRecord fields with no explicit mapping:\n")
+							for _, f := range diff.Unmapped {
+								fmt.Printf("  %s\n", f)
+							}
+						}
+						return nil
+					},
+				},
+			},
+		},
 		{
 			Name:  "aliases",
 			Usage: "List Elasticsearch aliases and associated indexes",
@@ -178,17 +375,199 @@ Lucene version: %s
 		},
 		{
 			Name:     "delete",
-			Usage:    "Delete an Elasticsearch index",
+			Usage:    "Delete an Elasticsearch index which has no alias",
 			Category: "Index actions",
+			Flags: []cli.Flag{
+				snapshotFlag,
+			},
 			Action: func(c *cli.Context) error {
 				es, err := client.NewESClient(url, v4)
 				if err != nil {
 					return err
 				}
+				// Only take a snapshot if the index will be deleted.
+				err = es.CanDelete(index)
+				if err != nil {
+					return err
+				}
+				if repo := c.String("snapshot"); repo != "" {
+					err = snapshot(es, repo, client.SnapshotName(index, time.Now()), index)
+					if err != nil {
+						return err
+					}
+				}
 				err = es.Delete(index)
 				return err
 			},
 		},
+		{
+			Name:     "prune",
+			Usage:    "Delete old Elasticsearch indexes for a prefix",
+			Category: "Index actions",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "prefix, p",
+					Value: "aleph",
+					Usage: "Index prefix to use: default is aleph",
+				},
+				cli.IntFlag{
+					Name:  "keep",
+					Value: 3,
+					Usage: "Number of the newest indexes to keep, at least 1, in addition to any with an alias",
+				},
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "Show which indexes would be deleted without deleting them",
+				},
+				snapshotFlag,
+			},
+			Action: func(c *cli.Context) error {
+				es, err := client.NewESClient(url, v4)
+				if err != nil {
+					return err
+				}
+				plan, err := es.PlanPrune(c.String("prefix"), c.Int("keep"))
+				if err != nil {
+					return err
+				}
+				for _, i := range plan.Keep {
+					fmt.Printf("keep    %s\n", i)
+				}
+				for _, i := range plan.Skipped {
+					fmt.Printf("skip    %s\n", i)
+				}
+				for _, i := range plan.Delete {
+					fmt.Printf("delete  %s\n", i)
+				}
+				if c.Bool("dry-run") || len(plan.Delete) == 0 {
+					return nil
+				}
+				if repo := c.String("snapshot"); repo != "" {
+					name := client.SnapshotName(c.String("prefix")+"-prune", time.Now())
+					err = snapshot(es, repo, name, plan.Delete...)
+					if err != nil {
+						return err
+					}
+				}
+				for _, i := range plan.Delete {
+					err = es.Delete(i)
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			Name:     "snapshot",
+			Usage:    "Back up Elasticsearch indexes to a snapshot repository and restore them",
+			Category: "Index actions",
+			Subcommands: []cli.Command{
+				{
+					Name:  "repository",
+					Usage: "Register a snapshot repository",
+					Flags: []cli.Flag{
+						repositoryFlag,
+						cli.StringFlag{
+							Name:  "type",
+							Value: "fs",
+							Usage: "Repository type, fs or s3: default is fs",
+						},
+						cli.StringFlag{
+							Name:  "location",
+							Usage: "Directory shared by every node for fs, or bucket name for s3",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						es, err := client.NewESClient(url, v4)
+						if err != nil {
+							return err
+						}
+						return es.CreateRepository(c.String("repository"), c.String("type"), c.String("location"))
+					},
+				},
+				{
+					Name:  "create",
+					Usage: "Snapshot the index given with --index",
+					Flags: []cli.Flag{
+						repositoryFlag,
+						cli.StringFlag{
+							Name:  "name",
+							Usage: "Snapshot name: default is the index name with the time appended",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						es, err := client.NewESClient(url, v4)
+						if err != nil {
+							return err
+						}
+						name := c.String("name")
+						if name == "" {
+							name = client.SnapshotName(index, time.Now())
+						}
+						return snapshot(es, c.String("repository"), name, index)
+					},
+				},
+				{
+					Name:  "list",
+					Usage: "List the snapshots in a repository",
+					Flags: []cli.Flag{
+						repositoryFlag,
+					},
+					Action: func(c *cli.Context) error {
+						es, err := client.NewESClient(url, v4)
+						if err != nil {
+							return err
+						}
+						snapshots, err := es.Snapshots(c.String("repository"))
+						if err != nil {
+							return err
+						}
+						for _, s := range snapshots {
+							fmt.Printf(`
+Name: %s
+  State: %s
+  Started: %s
+  Indexes: %s
+`, s.Snapshot, s.State, s.StartTime.Format(time.RFC3339), strings.Join(s.Indices, ", "))
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "restore",
+					Usage: "Restore the index given with --index from a snapshot under a new name",
+					Flags: []cli.Flag{
+						repositoryFlag,
+						cli.StringFlag{
+							Name:  "snapshot",
+							Usage: "Snapshot to restore from",
+						},
+						cli.StringFlag{
+							Name:  "as",
+							Usage: "Name of the restored index: default is a new timestamped index for the prefix",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						es, err := client.NewESClient(url, v4)
+						if err != nil {
+							return err
+						}
+						name := c.String("as")
+						if name == "" {
+							prefix := strings.SplitN(index, "-", 2)[0]
+							name = prefix + "-" + time.Now().UTC().Format(client.IndexTimeFormat)
+						}
+						err = es.Restore(c.String("repository"), c.String("snapshot"), index, name)
+						if err != nil {
+							return err
+						}
+						fmt.Printf("Restored %s from %s as %s\n", index, c.String("snapshot"), name)
+						return nil
+					},
+				},
+			},
+		},
 		{
 			Name:     "promote",
 			Usage:    "Promote Elasticsearch alias to prod",
@@ -199,35 +578,160 @@ Lucene version: %s
 					Value: "aleph",
 					Usage: "Index prefix to use: default is aleph",
 				},
+				aliasFlag,
+				envFlag,
+				sourceAliasesFlag,
 			},
 			Action: func(c *cli.Context) error {
 				es, err := client.NewESClient(url, v4)
 				if err != nil {
 					return err
 				}
+				es.Alias = alias(c)
+				es.SourceAliases = c.Bool("source-aliases")
 				err = es.Promote(index, c.String("prefix"))
 				return err
 			},
 		},
+		{
+			Name:     "demote",
+			Usage:    "Remove an Elasticsearch index from the prod alias",
+			Category: "Index actions",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "prefix, p",
+					Usage: "Index prefix to use: default is taken from the index name",
+				},
+				cli.BoolFlag{
+					Name:  "force",
+					Usage: "Demote the index even if it is the only one for its prefix on the alias",
+				},
+				aliasFlag,
+				envFlag,
+			},
+			Action: func(c *cli.Context) error {
+				es, err := client.NewESClient(url, v4)
+				if err != nil {
+					return err
+				}
+				es.Alias = alias(c)
+				err = es.Demote(index, c.String("prefix"), c.Bool("force"))
+				return err
+			},
+		},
+		{
+			Name:     "rollback",
+			Usage:    "Replace the current index for a prefix on the alias with the one promoted before it",
+			Category: "Index actions",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "prefix, p",
+					Value: "aleph",
+					Usage: "Index prefix to use: default is aleph",
+				},
+				cli.BoolFlag{
+					Name:  "yes, y",
+					Usage: "Roll back without asking when the current index is not in the promotion history",
+				},
+				aliasFlag,
+				envFlag,
+				sourceAliasesFlag,
+			},
+			Action: func(c *cli.Context) error {
+				es, err := client.NewESClient(url, v4)
+				if err != nil {
+					return err
+				}
+				es.Alias = alias(c)
+				es.SourceAliases = c.Bool("source-aliases")
+				confirm := func(index string) bool {
+					fmt.Printf("The current index is not in the promotion history, so the index to roll back to is %s, which may never have been live.\n", index)
+					return c.Bool("yes") || ask("Roll back to it?")
+				}
+				from, to, err := es.Rollback(c.String("prefix"), confirm)
+				if err != nil && !errors.Is(err, client.ErrHistory) {
+					return err
+				}
+				fmt.Printf("Rolled back %s from %s to %s\n", es.Alias, from, to)
+				return err
+			},
+		},
 		{
 			Name:      "reindex",
 			Usage:     "Reindex one index to another index.",
-			UsageText: "Use the Elasticsearch reindex API to copy one index to another. The doc source must be present in the original index.",
+			UsageText: "Use the Elasticsearch reindex API to copy one index to another, as a background task whose progress is shown until it finishes. The doc source must be present in the original index.",
 			Category:  "Index actions",
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:  "destination",
 					Usage: "Name of new index",
 				},
+				cli.StringFlag{
+					Name:  "mappings",
+					Usage: "JSON file with the settings and mappings for the new index: default is the mappings config",
+				},
+				cli.IntFlag{
+					Name:  "slices",
+					Usage: "Number of slices to split the reindex into: default lets Elasticsearch choose",
+				},
+				cli.StringFlag{
+					Name:  "script",
+					Usage: "Painless script to apply to each document",
+				},
+				cli.StringFlag{
+					Name:  "pipeline",
+					Usage: "Ingest pipeline to run each document through",
+				},
+				cli.StringFlag{
+					Name:  "task",
+					Usage: "Show the progress of a reindex task which has already been started instead of starting one",
+				},
+				cli.DurationFlag{
+					Name:  "interval",
+					Value: 10 * time.Second,
+					Usage: "How often to show progress",
+				},
 			},
 			Action: func(c *cli.Context) error {
 				es, err := client.NewESClient(url, v4)
 				if err != nil {
 					return err
 				}
-				count, err := es.Reindex(index, c.String("destination"))
-				fmt.Printf("%d documents reindexed\n", count)
-				return err
+				task := c.String("task")
+				if task == "" {
+					dest := c.String("destination")
+					if path := c.String("mappings"); path != "" {
+						b, err := ioutil.ReadFile(path)
+						if err != nil {
+							return err
+						}
+						err = es.CreateWith(dest, string(b))
+						if err != nil {
+							return err
+						}
+					} else {
+						cluster, err := es.Detect(url)
+						if err != nil {
+							return err
+						}
+						err = cluster.Create(dest)
+						if err != nil {
+							return err
+						}
+					}
+					task, err = es.StartReindex(index, dest, client.ReindexOptions{
+						Slices:   c.Int("slices"),
+						Script:   c.String("script"),
+						Pipeline: c.String("pipeline"),
+					})
+					if err != nil {
+						return err
+					}
+					fmt.Printf("Reindexing %s to %s in task %s\n", index, dest, task)
+				}
+				ctx, cancel := interruptible(0)
+				defer cancel()
+				return exitStatus(watchReindex(ctx, es, task, c.Duration("interval")))
 			},
 		},
 	}
@@ -237,3 +741,142 @@ Lucene version: %s
 		log.Fatal(err)
 	}
 }
+
+// Options for commands which change aliases.
+var (
+	aliasFlag = cli.StringFlag{
+		Name:  "alias",
+		Usage: "Primary alias to use: default is timdex-<env>",
+	}
+	envFlag = cli.StringFlag{
+		Name:  "env",
+		Value: "prod",
+		Usage: "Environment whose primary alias to use: default is prod",
+	}
+	sourceAliasesFlag = cli.BoolFlag{
+		Name:  "source-aliases",
+		Usage: "Also keep a per-source alias, such as timdex-prod-aleph, in sync",
+	}
+)
+
+// Options for commands which use snapshots.
+var (
+	repositoryFlag = cli.StringFlag{
+		Name:  "repository, r",
+		Value: "mario",
+		Usage: "Snapshot repository to use: default is mario",
+	}
+	snapshotFlag = cli.StringFlag{
+		Name:  "snapshot",
+		Usage: "Snapshot the indexes to this repository before deleting them",
+	}
+)
+
+// snapshot takes a snapshot of indexes and reports it.
+func snapshot(es *client.ESClient, repository string, name string, indexes ...string) error {
+	s, err := es.Snapshot(repository, name, indexes...)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Snapshot %s of %s saved to %s\n", s.Snapshot, strings.Join(s.Indices, ", "), repository)
+	return nil
+}
+
+// alias returns the primary alias given by the --alias or --env options.
+func alias(c *cli.Context) string {
+	if a := c.String("alias"); a != "" {
+		return a
+	}
+	return client.EnvironmentAlias(c.String("env"))
+}
+
+// watchReindex shows the progress of a reindex task every interval until
+// it finishes. If ctx is done first the task carries on in the cluster.
+func watchReindex(ctx context.Context, es *client.ESClient, task string, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		p, err := es.ReindexProgress(task)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d/%d documents: %d created, %d updated, %d conflicts\n",
+			p.Processed(), p.Total, p.Created, p.Updated, p.VersionConflicts)
+		if p.Completed {
+			if p.Error != "" {
+				return fmt.Errorf("Reindex task %s failed: %s", task, p.Error)
+			}
+			for _, f := range p.Failures {
+				log.Println("Could not reindex:", f)
+			}
+			if len(p.Failures) > 0 {
+				return fmt.Errorf("Reindex task %s finished with %d failures", task, len(p.Failures))
+			}
+			fmt.Printf("%d documents reindexed\n", p.Processed())
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("Stopped watching reindex task %s, which is still running: %w", task, ctx.Err())
+		}
+	}
+}
+
+// interruptible returns a context which is cancelled when the process
+// receives SIGINT or SIGTERM, or when the timeout has elapsed. A timeout
+// of zero means no timeout.
+func interruptible(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if timeout > 0 {
+		var stop context.CancelFunc
+		ctx, stop = context.WithTimeout(ctx, timeout)
+		parent := cancel
+		cancel = func() {
+			stop()
+			parent()
+		}
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case s := <-sigs:
+			log.Printf("Received %s, stopping", s)
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sigs)
+	}()
+	return ctx, cancel
+}
+
+// ask prompts for a yes or no answer on stdin, taking anything but yes
+// as no.
+func ask(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// exitStatus gives interrupted runs a distinct exit code: 130 when the
+// process was signalled and 124 when the timeout ran out. Runs where
+// Elasticsearch rejected documents exit with 3, runs where the new index
+// failed the quality gate exit with 4, and files skipped because their
+// source is unknown exit with 5.
+func exitStatus(err error) error {
+	switch {
+	case errors.Is(err, ingester.ErrUnknownSource):
+		return cli.NewExitError(err, 5)
+	case errors.Is(err, ingester.ErrGate):
+		return cli.NewExitError(err, 4)
+	case errors.Is(err, ingester.ErrBulkFailures):
+		return cli.NewExitError(err, 3)
+	case errors.Is(err, context.Canceled):
+		return cli.NewExitError(err, 130)
+	case errors.Is(err, context.DeadlineExceeded):
+		return cli.NewExitError(err, 124)
+	}
+	return err
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/mitlibraries/mario/pkg/ingester"
+	"github.com/urfave/cli"
+)
+
+func TestExitStatus(t *testing.T) {
+	var tests = []struct {
+		err  error
+		code int
+	}{
+		{ingester.ErrBulkFailures, 3},
+		{fmt.Errorf("2 documents: %w", ingester.ErrBulkFailures), 3},
+		{fmt.Errorf("Gate failed: %w", ingester.ErrGate), 4},
+		{fmt.Errorf("Ingest stopped: %w", context.Canceled), 130},
+		{fmt.Errorf("Ingest stopped: %w", context.DeadlineExceeded), 124},
+		{fmt.Errorf("Not ingesting file: %w", ingester.ErrUnknownSource), 5},
+	}
+	for _, tt := range tests {
+		var exit cli.ExitCoder
+		if !errors.As(exitStatus(tt.err), &exit) || exit.ExitCode() != tt.code {
+			t.Errorf("%v: expected exit code %d", tt.err, tt.code)
+		}
+	}
+	if exitStatus(nil) != nil {
+		t.Error("Expected no error for a successful run")
+	}
+	err := errors.New("other")
+	if exitStatus(err) != err {
+		t.Error("Expected other errors to be returned unchanged")
+	}
+}
@@ -2,7 +2,9 @@ package client
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
@@ -13,28 +15,112 @@ import (
 	aws "github.com/olivere/elastic/aws/v4"
 	"io/ioutil"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 )
 
-// Primary alias
-const primary = "timdex-prod"
+// DefaultAlias is the primary alias used when none is configured.
+const DefaultAlias = "timdex-prod"
 
-// Indexer provides an interface for interacting with an index.
+// EnvironmentAlias returns the primary alias for an environment in the
+// source layout, such as timdex-stage. An empty environment gives
+// DefaultAlias.
+func EnvironmentAlias(env string) string {
+	if env == "" {
+		return DefaultAlias
+	}
+	return "timdex-" + env
+}
+
+// ErrAliased is returned when deleting an index which has an alias.
+var ErrAliased = errors.New("Index has an alias")
+
+// ErrLastIndex is returned when removing an index from the primary alias
+// would leave no index for its prefix on the alias.
+var ErrLastIndex = errors.New("Index is the only one for its prefix on the primary alias")
+
+// ErrEncode is returned when a record cannot be encoded as a document.
+var ErrEncode = errors.New("Could not encode record")
+
+// ErrNotStarted is returned when adding to or removing from an index
+// before the bulk processor has been started.
+var ErrNotStarted = errors.New("Bulk processor has not been started")
+
+// Indexer provides an interface for interacting with an index. Add and
+// Remove queue a request with the bulk processor, so only problems with
+// the request itself are returned. Errors from Elasticsearch are reported
+// by Failures.
 type Indexer interface {
 	Current(string) (string, error)
 	Create(string) error
 	Start() error
 	Stop() error
-	Add(record.Record, string, string)
+	Flush() error
+	Failures() []BulkFailure
+	Add(record.Record, string, string) error
+	Remove(string, string, string) error
 	Promote(string, string) error
 	Delete(string) error
-	Reindex(string, string) (int64, error)
+	Count(string) (int64, error)
+	Hits(string, string) (int64, error)
+}
+
+// BulkFailure describes a document which could not be indexed, either
+// because Elasticsearch rejected it or because the bulk request failed
+// after being retried.
+type BulkFailure struct {
+	Identifier string `json:"identifier"`
+	Index      string `json:"index"`
+	Status     int    `json:"status,omitempty"`
+	Type       string `json:"type"`
+	Reason     string `json:"reason"`
+}
+
+// retryStatuses are the bulk item statuses which are retried with
+// backoff. Any other failure is permanent.
+var retryStatuses = map[int]bool{
+	http.StatusRequestTimeout:      true,
+	http.StatusTooManyRequests:     true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusInsufficientStorage: true,
+}
+
+// bulkFailures retries documents rejected with a retryable status and
+// collects those which could not be indexed.
+type bulkFailures struct {
+	client   *elastic.Client
+	backoff  elastic.Backoff
+	mu       sync.Mutex
+	failures []BulkFailure
 }
 
 // ESClient wraps an olivere/elastic client. Create a new client with the
 // NewESClient function.
+//
+// Alias is the primary alias which searches go through, and defaults to
+// DefaultAlias. When SourceAliases is set each index is also linked to a
+// per-source alias, such as timdex-prod-aleph, which is kept in sync with
+// the primary alias.
 type ESClient struct {
-	client *elastic.Client
-	bulker *elastic.BulkProcessor
+	Alias         string
+	SourceAliases bool
+	client        *elastic.Client
+	bulker        *elastic.BulkProcessor
+	failures      *bulkFailures
+}
+
+// primary returns the primary alias.
+func (c ESClient) primary() string {
+	if c.Alias == "" {
+		return DefaultAlias
+	}
+	return c.Alias
+}
+
+// SourceAlias returns the per-source alias for a prefix.
+func (c ESClient) SourceAlias(prefix string) string {
+	return c.primary() + "-" + prefix
 }
 
 // Current returns the name of the current index for the given prefix. A
@@ -46,7 +132,7 @@ func (c ESClient) Current(prefix string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	aliases := res.IndicesByAlias(primary)
+	aliases := res.IndicesByAlias(c.primary())
 	if len(aliases) == 0 {
 		return "", nil
 	} else if len(aliases) > 1 {
@@ -58,76 +144,356 @@ func (c ESClient) Current(prefix string) (string, error) {
 
 // Create the new index if it does not exist.
 func (c ESClient) Create(index string) error {
-	exists, err := c.client.IndexExists(index).Do(context.Background())
+	file, err := pkger.Open("/config/es_record_mappings.json")
 	if err != nil {
 		return err
 	}
-	if exists {
-		return nil
-	}
-	file, err := pkger.Open("/config/es_record_mappings.json")
+	defer file.Close()
+	mappings, err := ioutil.ReadAll(file)
 	if err != nil {
 		return err
 	}
-	mappings, err := ioutil.ReadAll(file)
+	return c.create(index, string(mappings))
+}
+
+// create makes an index with the given settings and mappings if it does
+// not already exist.
+func (c ESClient) create(index string, body string) error {
+	exists, err := c.client.IndexExists(index).Do(context.Background())
 	if err != nil {
 		return err
 	}
+	if exists {
+		return nil
+	}
 	_, err = c.client.
 		CreateIndex(index).
-		BodyString(string(mappings)).
+		BodyString(body).
 		Do(context.Background())
 	return err
 }
 
-// Start the bulk processor.
+// Start the bulk processor. Bulk requests which fail are retried with
+// exponential backoff, as are documents rejected with a retryable status
+// such as 429. Documents which still could not be indexed are reported by
+// Failures.
 func (c *ESClient) Start() error {
+	backoff := elastic.NewExponentialBackoff(200*time.Millisecond, time.Minute)
+	c.failures = &bulkFailures{client: c.client, backoff: backoff}
+	// Retrying individual documents is left to the After callback. The
+	// bulk processor's own item retries replace the response, which would
+	// lose any permanent failures that came back alongside them.
 	bulker, err := c.client.
 		BulkProcessor().
 		Name("BulkProcessor").
 		Workers(2).
+		Backoff(backoff).
+		RetryItemStatusCodes().
+		After(c.failures.after).
 		Do(context.Background())
 	c.bulker = bulker
 	return err
 }
 
+// after is called by the bulk processor once a bulk request has been
+// committed. It runs on the bulk processor's worker, so retries here hold
+// back further requests until they are done.
+func (f *bulkFailures) after(id int64, requests []elastic.BulkableRequest, res *elastic.BulkResponse, err error) {
+	if err != nil {
+		for _, r := range requests {
+			index, docID := requestMeta(r)
+			f.add(BulkFailure{Identifier: docID, Index: index, Type: "request_failed", Reason: err.Error()})
+		}
+		return
+	}
+	for retry := 0; res != nil && res.Errors; retry++ {
+		var again []elastic.BulkableRequest
+		for i, item := range res.Items {
+			for op, result := range item {
+				if result.Status >= 200 && result.Status <= 299 {
+					continue
+				}
+				// Deleting a document that is not in the index is fine.
+				if op == "delete" && result.Status == http.StatusNotFound {
+					continue
+				}
+				if retryStatuses[result.Status] {
+					again = append(again, requests[i])
+					continue
+				}
+				f.add(failure(result))
+			}
+		}
+		if len(again) == 0 {
+			return
+		}
+		wait, ok := f.backoff.Next(retry)
+		if !ok {
+			for _, item := range res.Failed() {
+				if retryStatuses[item.Status] {
+					f.add(failure(item))
+				}
+			}
+			return
+		}
+		time.Sleep(wait)
+		requests = again
+		res, err = f.client.Bulk().Add(requests...).Do(context.Background())
+		if err != nil {
+			f.after(id, requests, nil, err)
+			return
+		}
+	}
+}
+
+func (f *bulkFailures) add(bf BulkFailure) {
+	f.mu.Lock()
+	f.failures = append(f.failures, bf)
+	f.mu.Unlock()
+}
+
+// failure describes a failed bulk response item.
+func failure(item *elastic.BulkResponseItem) BulkFailure {
+	bf := BulkFailure{Identifier: item.Id, Index: item.Index, Status: item.Status}
+	if item.Error != nil {
+		bf.Type = item.Error.Type
+		bf.Reason = item.Error.Reason
+	}
+	return bf
+}
+
+// requestMeta returns the index and document ID from a bulk request's
+// action line.
+func requestMeta(r elastic.BulkableRequest) (string, string) {
+	lines, err := r.Source()
+	if err != nil || len(lines) == 0 {
+		return "", ""
+	}
+	var action map[string]struct {
+		Index string `json:"_index"`
+		ID    string `json:"_id"`
+	}
+	if json.Unmarshal([]byte(lines[0]), &action) != nil {
+		return "", ""
+	}
+	for _, meta := range action {
+		return meta.Index, meta.ID
+	}
+	return "", ""
+}
+
+// Failures returns the documents which could not be indexed since the
+// bulk processor was started.
+func (c *ESClient) Failures() []BulkFailure {
+	if c.failures == nil {
+		return nil
+	}
+	c.failures.mu.Lock()
+	defer c.failures.mu.Unlock()
+	return append([]BulkFailure(nil), c.failures.failures...)
+}
+
 // Stop the bulk processor.
 func (c *ESClient) Stop() error {
 	return c.bulker.Stop()
 }
 
+// Flush sends any outstanding bulk requests and waits for them to
+// complete.
+func (c *ESClient) Flush() error {
+	return c.bulker.Flush()
+}
+
+// document encodes a record for a bulk request. Encoding it up front
+// means a record which cannot be encoded is reported on its own, rather
+// than failing the whole bulk request it would have been sent in.
+func document(r record.Record) (json.RawMessage, error) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w %s: %v", ErrEncode, r.Identifier, err)
+	}
+	return json.RawMessage(b), nil
+}
+
 // Add a record using a bulk processor.
-func (c *ESClient) Add(record record.Record, index string, rtype string) {
+func (c *ESClient) Add(record record.Record, index string, rtype string) error {
+	if c.bulker == nil {
+		return ErrNotStarted
+	}
+	doc, err := document(record)
+	if err != nil {
+		return err
+	}
 	d := elastic.NewBulkIndexRequest().
 		Index(index).
 		Id(record.Identifier).
 		Type(rtype).
-		Doc(record)
+		Doc(doc)
+	c.bulker.Add(d)
+	return nil
+}
+
+// Remove a document by identifier using a bulk processor.
+func (c *ESClient) Remove(id string, index string, rtype string) error {
+	if c.bulker == nil {
+		return ErrNotStarted
+	}
+	d := elastic.NewBulkDeleteRequest().
+		Index(index).
+		Id(id).
+		Type(rtype)
 	c.bulker.Add(d)
+	return nil
 }
 
 // Promote will add the given index to the primary alias. If there is an
 // existing index matching the prefix linked to the primary alias it will
-// be removed from the alias. This action is atomic.
+// be removed from the alias. When SourceAliases is set the per-source
+// alias is moved to the index in the same action. This action is atomic.
+//
+// The index is then added to the promotion history for the prefix. If
+// that fails the index has still been promoted, and the returned error
+// wraps ErrHistory.
 func (c ESClient) Promote(index string, prefix string) error {
+	err := c.promote(index, prefix)
+	if err != nil {
+		return err
+	}
+	err = c.addHistory(index, prefix)
+	if err != nil {
+		return fmt.Errorf("%w for %s: %v", ErrHistory, index, err)
+	}
+	return nil
+}
+
+// promote moves the aliases for a prefix to index.
+func (c ESClient) promote(index string, prefix string) error {
+	primary := c.primary()
 	svc := c.client.Alias().Add(index, primary)
 	current, err := c.Current(prefix)
 	if err != nil {
 		return err
 	}
-	if current != "" {
+	if current != "" && current != index {
 		svc.Remove(current, primary)
 	}
+	if c.SourceAliases {
+		alias := c.SourceAlias(prefix)
+		res, err := c.client.Aliases().Index(prefix + "*").Do(context.Background())
+		if err != nil {
+			return err
+		}
+		svc.Add(index, alias)
+		for _, name := range res.IndicesByAlias(alias) {
+			if name != index {
+				svc.Remove(name, alias)
+			}
+		}
+	}
 	_, err = svc.Do(context.Background())
 	return err
 }
 
-// Delete an index.
+// Demote will remove the given index from the primary alias, and from its
+// per-source alias if it is linked to one. It refuses to if no other index
+// matching the prefix is linked to the primary alias, unless force is set,
+// so that every source stays searchable. If prefix is empty it is taken
+// from the index name, up to the first hyphen.
+func (c ESClient) Demote(index string, prefix string, force bool) error {
+	if prefix == "" {
+		prefix = strings.SplitN(index, "-", 2)[0]
+	}
+	primary := c.primary()
+	res, err := c.client.Aliases().Index(prefix + "*").Do(context.Background())
+	if err != nil {
+		return err
+	}
+	err = canDemote(index, primary, res.IndicesByAlias(primary), force)
+	if err != nil {
+		return err
+	}
+	svc := c.client.Alias().Remove(index, primary)
+	alias := c.SourceAlias(prefix)
+	for _, name := range res.IndicesByAlias(alias) {
+		if name == index {
+			svc.Remove(index, alias)
+		}
+	}
+	_, err = svc.Do(context.Background())
+	return err
+}
+
+// canDemote checks that index can be removed from the primary alias.
+// linked are the indexes for its prefix which are on the alias. Unless
+// force is set, one of them must be another index.
+func canDemote(index string, primary string, linked []string, force bool) error {
+	var found, others int
+	for _, name := range linked {
+		if name == index {
+			found++
+		} else {
+			others++
+		}
+	}
+	if found == 0 {
+		return fmt.Errorf("Index %s is not linked to %s", index, primary)
+	}
+	if others == 0 && !force {
+		return fmt.Errorf("Could not demote %s: %w", index, ErrLastIndex)
+	}
+	return nil
+}
+
+// Delete an index. An index with an alias, such as the index currently
+// linked to the primary alias, is not deleted.
 func (c ESClient) Delete(index string) error {
-	_, err := c.client.DeleteIndex(index).Do(context.Background())
+	err := c.CanDelete(index)
+	if err != nil {
+		return err
+	}
+	_, err = c.client.DeleteIndex(index).Do(context.Background())
 	return err
 }
 
+// CanDelete checks that an index has no alias, so that Delete would go
+// ahead. The returned error wraps ErrAliased if it has one.
+func (c ESClient) CanDelete(index string) error {
+	res, err := c.client.Aliases().Index(index).Do(context.Background())
+	if err != nil {
+		return err
+	}
+	for name, result := range res.Indices {
+		if len(result.Aliases) > 0 {
+			return fmt.Errorf("Could not delete %s: %w", name, ErrAliased)
+		}
+	}
+	return nil
+}
+
+// Count returns the number of documents in an index. The index is
+// refreshed first so that documents which have just been added are
+// counted.
+func (c ESClient) Count(index string) (int64, error) {
+	_, err := c.client.Refresh(index).Do(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	return c.client.Count(index).Do(context.Background())
+}
+
+// Hits returns the number of documents in an index which match a query
+// in the query string syntax. Like Count, the index is refreshed first.
+func (c ESClient) Hits(index string, query string) (int64, error) {
+	_, err := c.client.Refresh(index).Do(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	return c.client.
+		Count(index).
+		Query(elastic.NewQueryStringQuery(query)).
+		Do(context.Background())
+}
+
 // Indexes returns a list of indexes in a cluster.
 func (c ESClient) Indexes() (elastic.CatIndicesResponse, error) {
 	return c.client.
@@ -147,20 +513,6 @@ func (c ESClient) Ping(url string) (*elastic.PingResult, error) {
 	return res, err
 }
 
-// Reindex the source index to the destination index. Returns the number
-// of documents reindexed.
-func (c ESClient) Reindex(source string, dest string) (int64, error) {
-	resp, err := c.client.
-		Reindex().
-		SourceIndex(source).
-		DestinationIndex(dest).
-		Do(context.Background())
-	if err != nil {
-		return 0, err
-	}
-	return resp.Total, nil
-}
-
 // NewESClient creates a new Elasticsearch client.
 func NewESClient(url string, v4 bool) (*ESClient, error) {
 	var client *http.Client
@@ -0,0 +1,186 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/olivere/elastic"
+)
+
+// bulkServer answers each bulk request with the next of responses, and
+// keeps the bodies of the requests it was sent.
+type bulkServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	responses []string
+	bodies    []string
+}
+
+func newBulkServer(t *testing.T, responses ...string) (*bulkServer, *elastic.Client) {
+	s := &bulkServer{responses: responses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		body, _ := ioutil.ReadAll(r.Body)
+		s.bodies = append(s.bodies, string(body))
+		if len(s.responses) == 0 {
+			http.Error(w, "unexpected request", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(s.responses[0]))
+		s.responses = s.responses[1:]
+	}))
+	c, err := elastic.NewClient(
+		elastic.SetURL(s.URL),
+		elastic.SetSniff(false),
+		elastic.SetHealthcheck(false),
+	)
+	if err != nil {
+		s.Close()
+		t.Fatal(err)
+	}
+	return s, c
+}
+
+func bulkRequests(ids ...string) []elastic.BulkableRequest {
+	var requests []elastic.BulkableRequest
+	for _, id := range ids {
+		requests = append(requests, elastic.NewBulkIndexRequest().
+			Index("aleph-new").Type("Record").Id(id).Doc(map[string]string{"title": id}))
+	}
+	return requests
+}
+
+// commit sends requests as the bulk processor would and hands the
+// response to the After callback.
+func commit(t *testing.T, f *bulkFailures, requests []elastic.BulkableRequest) {
+	res, err := f.client.Bulk().Add(requests...).Do(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.after(1, requests, res, nil)
+}
+
+func item(id string, status int, errType string) string {
+	s := `{"index":{"_index":"aleph-new","_type":"Record","_id":"` + id + `","status":` + strconv.Itoa(status)
+	if errType != "" {
+		s += `,"error":{"type":"` + errType + `","reason":"` + errType + ` for ` + id + `"}`
+	}
+	return s + `}}`
+}
+
+func response(items ...string) string {
+	errs := "false"
+	for _, i := range items {
+		if strings.Contains(i, `"error"`) {
+			errs = "true"
+		}
+	}
+	return `{"took":1,"errors":` + errs + `,"items":[` + strings.Join(items, ",") + `]}`
+}
+
+func TestBulkRetriesThenSucceeds(t *testing.T) {
+	s, c := newBulkServer(t,
+		response(item("1", 429, "es_rejected_execution_exception"), item("2", 201, "")),
+		response(item("1", 201, "")),
+	)
+	defer s.Close()
+	f := &bulkFailures{client: c, backoff: elastic.NewSimpleBackoff(1)}
+	commit(t, f, bulkRequests("1", "2"))
+	if len(f.failures) != 0 {
+		t.Error("Expected no failures, got", f.failures)
+	}
+	if len(s.bodies) != 2 {
+		t.Fatal("Expected the request to be retried once, got", len(s.bodies))
+	}
+	if !strings.Contains(s.bodies[1], `"_id":"1"`) || strings.Contains(s.bodies[1], `"_id":"2"`) {
+		t.Error("Expected only document 1 to be retried, got", s.bodies[1])
+	}
+}
+
+func TestBulkRecordsPermanentFailures(t *testing.T) {
+	s, c := newBulkServer(t,
+		response(item("1", 201, ""), item("2", 400, "mapper_parsing_exception")),
+	)
+	defer s.Close()
+	f := &bulkFailures{client: c, backoff: elastic.NewSimpleBackoff(1)}
+	commit(t, f, bulkRequests("1", "2"))
+	expected := BulkFailure{
+		Identifier: "2",
+		Index:      "aleph-new",
+		Status:     400,
+		Type:       "mapper_parsing_exception",
+		Reason:     "mapper_parsing_exception for 2",
+	}
+	if len(f.failures) != 1 || f.failures[0] != expected {
+		t.Errorf("Expected %+v, got %+v", expected, f.failures)
+	}
+	if len(s.bodies) != 1 {
+		t.Error("Expected a permanent failure not to be retried, got", len(s.bodies))
+	}
+}
+
+func TestBulkBackoffExhausted(t *testing.T) {
+	s, c := newBulkServer(t,
+		response(item("1", 429, "es_rejected_execution_exception")),
+		response(item("1", 429, "es_rejected_execution_exception")),
+	)
+	defer s.Close()
+	f := &bulkFailures{client: c, backoff: elastic.NewSimpleBackoff(1)}
+	commit(t, f, bulkRequests("1"))
+	if len(s.bodies) != 2 {
+		t.Error("Expected one retry, got", len(s.bodies)-1)
+	}
+	if len(f.failures) != 1 || f.failures[0].Identifier != "1" || f.failures[0].Status != 429 {
+		t.Error("Expected document 1 to fail with 429, got", f.failures)
+	}
+}
+
+func TestBulkRequestFailed(t *testing.T) {
+	f := &bulkFailures{backoff: elastic.NewSimpleBackoff(1)}
+	f.after(1, bulkRequests("1", "2"), nil, errors.New("connection refused"))
+	if len(f.failures) != 2 {
+		t.Fatal("Expected both documents to fail, got", f.failures)
+	}
+	for i, id := range []string{"1", "2"} {
+		bf := f.failures[i]
+		if bf.Identifier != id || bf.Index != "aleph-new" || bf.Type != "request_failed" || bf.Reason != "connection refused" {
+			t.Errorf("Unexpected failure %+v", bf)
+		}
+	}
+}
+
+func TestCanDemote(t *testing.T) {
+	var tests = []struct {
+		name   string
+		linked []string
+		force  bool
+		err    error
+	}{
+		{"last index", []string{"aleph-1"}, false, ErrLastIndex},
+		{"last index forced", []string{"aleph-1"}, true, nil},
+		{"another index", []string{"aleph-1", "aleph-2"}, false, nil},
+		{"another index forced", []string{"aleph-1", "aleph-2"}, true, nil},
+	}
+	for _, tt := range tests {
+		err := canDemote("aleph-1", "timdex-prod", tt.linked, tt.force)
+		if tt.err == nil && err != nil {
+			t.Errorf("%s: expected no error, got %v", tt.name, err)
+		} else if tt.err != nil && !errors.Is(err, tt.err) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.err, err)
+		}
+	}
+
+	err := canDemote("aleph-1", "timdex-prod", []string{"aleph-2"}, true)
+	if err == nil || !strings.Contains(err.Error(), "not linked") {
+		t.Error("Expected an index not on the alias to be refused, got", err)
+	}
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/olivere/elastic"
+)
+
+// HistoryIndex holds the indexes which have been promoted for each prefix
+// on each primary alias, so that a rollback only returns to an index
+// which has been live.
+const HistoryIndex = "mario-history"
+
+// historyLength is the number of promotions kept for each prefix.
+const historyLength = 20
+
+// ErrHistory is returned when an index was promoted but the promotion
+// could not be added to its history.
+var ErrHistory = errors.New("Could not record promotion history")
+
+// history is the document kept in HistoryIndex for a prefix.
+type history struct {
+	Indexes []string `json:"indexes"`
+}
+
+// historyID returns the ID of the history document for a prefix.
+func (c ESClient) historyID(prefix string) string {
+	return c.primary() + "-" + prefix
+}
+
+// History returns the indexes which have been promoted for a prefix on
+// the primary alias, oldest first. It is empty if nothing has been
+// promoted since the history was first kept.
+func (c ESClient) History(prefix string) ([]string, error) {
+	res, err := c.client.
+		Get().
+		Index(HistoryIndex).
+		Type("_doc").
+		Id(c.historyID(prefix)).
+		Do(context.Background())
+	if elastic.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var h history
+	if res.Source != nil {
+		err = json.Unmarshal(*res.Source, &h)
+	}
+	return h.Indexes, err
+}
+
+// saveHistory replaces the history for a prefix, keeping only the latest
+// promotions.
+func (c ESClient) saveHistory(prefix string, indexes []string) error {
+	if len(indexes) > historyLength {
+		indexes = indexes[len(indexes)-historyLength:]
+	}
+	_, err := c.client.
+		Index().
+		Index(HistoryIndex).
+		Type("_doc").
+		Id(c.historyID(prefix)).
+		BodyJson(history{Indexes: indexes}).
+		Refresh("true").
+		Do(context.Background())
+	return err
+}
+
+// addHistory adds a newly promoted index to the history for a prefix.
+func (c ESClient) addHistory(index string, prefix string) error {
+	indexes, err := c.History(prefix)
+	if err != nil {
+		return err
+	}
+	if len(indexes) > 0 && indexes[len(indexes)-1] == index {
+		return nil
+	}
+	return c.saveHistory(prefix, append(indexes, index))
+}
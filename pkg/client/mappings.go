@@ -0,0 +1,114 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// Difference is a value which differs between the mappings config and a
+// live index. Path is the dotted path to the value. Expected is nil if the
+// value is only in the index, and Actual is nil if it is missing from the
+// index.
+type Difference struct {
+	Path     string
+	Expected interface{}
+	Actual   interface{}
+}
+
+// MappingDiff compares the mappings and analysis settings of a live index
+// with the embedded mappings config. Unmapped lists the record.Record
+// fields the config has no explicit mapping for.
+type MappingDiff struct {
+	Index    string
+	Mappings []Difference
+	Settings []Difference
+	Unmapped []string
+}
+
+// diffJSON compares two decoded JSON values, descending into objects. The
+// index returns settings as strings, so leaf values are compared by their
+// string form.
+func diffJSON(path string, expected interface{}, actual interface{}) []Difference {
+	em, eok := expected.(map[string]interface{})
+	am, aok := actual.(map[string]interface{})
+	if eok && aok {
+		keys := make(map[string]bool)
+		for k := range em {
+			keys[k] = true
+		}
+		for k := range am {
+			keys[k] = true
+		}
+		var sorted []string
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		var diffs []Difference
+		for _, k := range sorted {
+			p := k
+			if path != "" {
+				p = path + "." + k
+			}
+			diffs = append(diffs, diffJSON(p, em[k], am[k])...)
+		}
+		return diffs
+	}
+	if expected == nil && actual == nil {
+		return nil
+	}
+	if expected == nil || actual == nil || fmt.Sprint(expected) != fmt.Sprint(actual) {
+		return []Difference{{Path: path, Expected: expected, Actual: actual}}
+	}
+	return nil
+}
+
+// unmapped returns the fields which have no entry in properties.
+func unmapped(fields []string, properties map[string]interface{}) []string {
+	var missing []string
+	for _, f := range fields {
+		if _, ok := properties[f]; !ok {
+			missing = append(missing, f)
+		}
+	}
+	return missing
+}
+
+// DiffMappings compares the live mappings and analysis settings of an
+// index with the embedded mappings config.
+func (c ESClient) DiffMappings(index string) (*MappingDiff, error) {
+	config, err := mappingsConfig()
+	if err != nil {
+		return nil, err
+	}
+	return c.diffMappings(index, config, lookup(config, "mappings", "Record", "properties"))
+}
+
+// diffMappings compares an index with config, whose mappings for
+// record.Record are properties.
+func (c ESClient) diffMappings(index string, config map[string]interface{}, properties map[string]interface{}) (*MappingDiff, error) {
+	mappings, err := c.client.GetMapping().Index(index).Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	settings, err := c.client.IndexGetSettings(index).Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if len(mappings) != 1 || len(settings) != 1 {
+		return nil, fmt.Errorf("Expected %s to be a single index", index)
+	}
+
+	diff := &MappingDiff{Index: index}
+	for name, m := range mappings {
+		diff.Index = name
+		diff.Mappings = diffJSON("mappings", lookup(config, "mappings"), lookup(m, "mappings"))
+	}
+	for _, s := range settings {
+		diff.Settings = diffJSON("settings.analysis",
+			lookup(config, "settings", "analysis"), lookup(s.Settings, "index", "analysis"))
+	}
+	diff.Unmapped = unmapped(RecordFields(), properties)
+	return diff, nil
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestDiffJSON(t *testing.T) {
+	var expected, actual map[string]interface{}
+	json.Unmarshal([]byte(`{"properties": {
+		"isbns": {"type": "text"},
+		"title": {"type": "text", "fields": {"exact_value": {"type": "keyword", "ignore_above": 256}}},
+		"edition": {"type": "text"}
+	}}`), &expected)
+	json.Unmarshal([]byte(`{"properties": {
+		"isbns": {"type": "keyword"},
+		"title": {"type": "text", "fields": {"exact_value": {"type": "keyword", "ignore_above": "256"}}},
+		"notes": {"type": "text"}
+	}}`), &actual)
+
+	diffs := diffJSON("mappings", expected, actual)
+	paths := []string{
+		"mappings.properties.edition",
+		"mappings.properties.isbns.type",
+		"mappings.properties.notes",
+	}
+	var got []string
+	for _, d := range diffs {
+		got = append(got, d.Path)
+	}
+	if !reflect.DeepEqual(got, paths) {
+		t.Fatal("Expected match, got", got)
+	}
+	if diffs[0].Actual != nil || diffs[2].Expected != nil {
+		t.Error("Expected missing values to be nil, got", diffs)
+	}
+}
+
+func TestUnmapped(t *testing.T) {
+	properties, err := recordMappings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := unmapped([]string{"isbns", "title", "not_a_field"}, properties)
+	if !reflect.DeepEqual(missing, []string{"not_a_field"}) {
+		t.Error("Expected match, got", missing)
+	}
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// IndexTimeFormat is the format of the timestamp in index names, which
+// are made up of a prefix and a timestamp, such as
+// aleph-2020-04-08t14-02-53z.
+const IndexTimeFormat = "2006-01-02t15-04-05z"
+
+// IndexTime returns the timestamp embedded in an index name for the given
+// prefix. The boolean is false if the name does not have one.
+func IndexTime(index string, prefix string) (time.Time, bool) {
+	if !strings.HasPrefix(index, prefix+"-") {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(IndexTimeFormat, strings.TrimPrefix(index, prefix+"-"))
+	return t, err == nil
+}
+
+// PrunePlan sorts the indexes for a prefix into those to keep and those
+// to delete. Indexes without a timestamp in their name are never deleted
+// and are listed in Skipped.
+type PrunePlan struct {
+	Keep    []string
+	Delete  []string
+	Skipped []string
+}
+
+// planPrune keeps the newest keep indexes and any aliased indexes, and
+// marks the rest for deletion. aliased maps each index name to whether it
+// has an alias.
+func planPrune(prefix string, aliased map[string]bool, keep int) *PrunePlan {
+	plan := &PrunePlan{}
+	var dated []string
+	times := make(map[string]time.Time)
+	for index := range aliased {
+		t, ok := IndexTime(index, prefix)
+		if !ok {
+			plan.Skipped = append(plan.Skipped, index)
+			continue
+		}
+		times[index] = t
+		dated = append(dated, index)
+	}
+	sort.Slice(dated, func(i, j int) bool {
+		return times[dated[i]].After(times[dated[j]])
+	})
+	for n, index := range dated {
+		if n < keep || aliased[index] {
+			plan.Keep = append(plan.Keep, index)
+		} else {
+			plan.Delete = append(plan.Delete, index)
+		}
+	}
+	sort.Strings(plan.Skipped)
+	return plan
+}
+
+// PlanPrune works out which indexes for a prefix to delete so that only
+// the newest keep indexes remain, along with any that have an alias. keep
+// must be at least one.
+func (c ESClient) PlanPrune(prefix string, keep int) (*PrunePlan, error) {
+	if keep < 1 {
+		return nil, fmt.Errorf("At least one index must be kept, not %d", keep)
+	}
+	res, err := c.client.Aliases().Index(prefix + "-*").Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	aliased := make(map[string]bool)
+	for index, result := range res.Indices {
+		aliased[index] = len(result.Aliases) > 0
+	}
+	return planPrune(prefix, aliased, keep), nil
+}
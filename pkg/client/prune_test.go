@@ -0,0 +1,37 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPlanPrune(t *testing.T) {
+	aliased := map[string]bool{
+		"aleph-2020-01-01t00-00-00z": false,
+		"aleph-2020-02-01t00-00-00z": true,
+		"aleph-2020-03-01t00-00-00z": false,
+		"aleph-2020-04-01t00-00-00z": false,
+		"aleph-2020-05-01t00-00-00z": false,
+		"aleph-test":                 false,
+	}
+	plan := planPrune("aleph", aliased, 2)
+	keep := []string{"aleph-2020-05-01t00-00-00z", "aleph-2020-04-01t00-00-00z", "aleph-2020-02-01t00-00-00z"}
+	if !reflect.DeepEqual(plan.Keep, keep) {
+		t.Error("Expected match, got", plan.Keep)
+	}
+	del := []string{"aleph-2020-03-01t00-00-00z", "aleph-2020-01-01t00-00-00z"}
+	if !reflect.DeepEqual(plan.Delete, del) {
+		t.Error("Expected match, got", plan.Delete)
+	}
+	if !reflect.DeepEqual(plan.Skipped, []string{"aleph-test"}) {
+		t.Error("Expected match, got", plan.Skipped)
+	}
+}
+
+func TestPlanPruneKeepsAnIndex(t *testing.T) {
+	for _, keep := range []int{0, -1} {
+		if _, err := (ESClient{}).PlanPrune("aleph", keep); err == nil {
+			t.Error("Expected an error keeping", keep)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/olivere/elastic"
+)
+
+// ReindexOptions change how documents are copied by StartReindex. Slices
+// splits the reindex into that many parallel parts, and 0 lets the
+// cluster choose. Script is a painless script and Pipeline the name of an
+// ingest pipeline, either of which is applied to each document.
+type ReindexOptions struct {
+	Slices   int
+	Script   string
+	Pipeline string
+}
+
+// ReindexProgress is the state of a reindex task. Failures describes any
+// documents which could not be copied, and Error is set if the task
+// itself failed.
+type ReindexProgress struct {
+	Completed        bool
+	Total            int64
+	Created          int64
+	Updated          int64
+	Deleted          int64
+	VersionConflicts int64
+	Noops            int64
+	Failures         []string
+	Error            string
+}
+
+// Processed returns the number of documents the task has dealt with.
+func (p ReindexProgress) Processed() int64 {
+	return p.Created + p.Updated + p.Deleted + p.VersionConflicts + p.Noops
+}
+
+// taskResponse is the part of the tasks API response for a reindex task
+// which mario uses.
+type taskResponse struct {
+	Completed bool `json:"completed"`
+	Task      struct {
+		Status reindexStatus `json:"status"`
+	} `json:"task"`
+	Response *struct {
+		reindexStatus
+		Failures []json.RawMessage `json:"failures"`
+	} `json:"response"`
+	Error json.RawMessage `json:"error"`
+}
+
+// reindexStatus counts the documents a reindex task has dealt with.
+type reindexStatus struct {
+	Total            int64 `json:"total"`
+	Created          int64 `json:"created"`
+	Updated          int64 `json:"updated"`
+	Deleted          int64 `json:"deleted"`
+	VersionConflicts int64 `json:"version_conflicts"`
+	Noops            int64 `json:"noops"`
+}
+
+// parseTask reads the progress of a reindex task from a tasks API
+// response. Once the task has completed the counts are taken from its
+// response.
+func parseTask(body []byte) (*ReindexProgress, error) {
+	var res taskResponse
+	err := json.Unmarshal(body, &res)
+	if err != nil {
+		return nil, err
+	}
+	status := res.Task.Status
+	p := &ReindexProgress{Completed: res.Completed}
+	if res.Response != nil {
+		status = res.Response.reindexStatus
+		for _, f := range res.Response.Failures {
+			p.Failures = append(p.Failures, string(f))
+		}
+	}
+	if len(res.Error) > 0 && string(res.Error) != "null" {
+		p.Error = string(res.Error)
+	}
+	p.Total = status.Total
+	p.Created = status.Created
+	p.Updated = status.Updated
+	p.Deleted = status.Deleted
+	p.VersionConflicts = status.VersionConflicts
+	p.Noops = status.Noops
+	return p, nil
+}
+
+// CreateWith creates an index with the given settings and mappings, in
+// place of those in the embedded config, if it does not exist.
+func (c ESClient) CreateWith(index string, body string) error {
+	return c.create(index, body)
+}
+
+// StartReindex starts copying the source index to the destination index
+// as a background task on the cluster, and returns the task's ID. The
+// destination index should already exist.
+func (c ESClient) StartReindex(source string, dest string, opts ReindexOptions) (string, error) {
+	destination := elastic.NewReindexDestination().Index(dest)
+	if opts.Pipeline != "" {
+		destination.Pipeline(opts.Pipeline)
+	}
+	svc := c.client.
+		Reindex().
+		SourceIndex(source).
+		Destination(destination)
+	if opts.Slices > 0 {
+		svc.Slices(opts.Slices)
+	} else {
+		svc.Slices("auto")
+	}
+	if opts.Script != "" {
+		svc.Script(elastic.NewScript(opts.Script).Lang("painless"))
+	}
+	res, err := svc.DoAsync(context.Background())
+	if err != nil {
+		return "", err
+	}
+	return res.TaskId, nil
+}
+
+// ReindexProgress returns the progress of a reindex task started with
+// StartReindex.
+func (c ESClient) ReindexProgress(task string) (*ReindexProgress, error) {
+	res, err := c.client.PerformRequest(context.Background(), elastic.PerformRequestOptions{
+		Method: "GET",
+		Path:   "/_tasks/" + url.PathEscape(task),
+	})
+	if err != nil {
+		return nil, err
+	}
+	p, err := parseTask(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read status of task %s: %w", task, err)
+	}
+	return p, nil
+}
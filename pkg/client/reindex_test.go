@@ -0,0 +1,38 @@
+package client
+
+import "testing"
+
+func TestParseTaskRunning(t *testing.T) {
+	body := `{"completed":false,"task":{"node":"n1","id":42,"action":"indices:data/write/reindex",
+		"status":{"total":1000,"created":300,"updated":20,"deleted":0,"version_conflicts":5,"noops":0}}}`
+	p, err := parseTask([]byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Completed || p.Total != 1000 || p.Processed() != 325 {
+		t.Error("Unexpected progress", p)
+	}
+}
+
+func TestParseTaskCompleted(t *testing.T) {
+	body := `{"completed":true,"task":{"status":{"total":10,"created":5}},
+		"response":{"took":12,"total":10,"created":8,"updated":0,"failures":[{"index":"aleph-2","id":"1","cause":{"type":"mapper_parsing_exception"}}]}}`
+	p, err := parseTask([]byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Completed || p.Created != 8 || len(p.Failures) != 1 || p.Error != "" {
+		t.Error("Unexpected progress", p)
+	}
+}
+
+func TestParseTaskError(t *testing.T) {
+	body := `{"completed":true,"task":{"status":{"total":10}},"error":{"type":"index_not_found_exception"}}`
+	p, err := parseTask([]byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Error == "" {
+		t.Error("Expected an error")
+	}
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotConfirmed is returned when a rollback to an index which may never
+// have been live is not confirmed.
+var ErrNotConfirmed = errors.New("Rollback not confirmed")
+
+// previousIndex returns the newest of indexes which is older than
+// current, going by the timestamps in their names. The boolean is false if
+// there is no such index.
+func previousIndex(prefix string, current string, indexes []string) (string, bool) {
+	limit, ok := IndexTime(current, prefix)
+	if !ok {
+		return "", false
+	}
+	var previous string
+	var latest time.Time
+	for _, index := range indexes {
+		t, ok := IndexTime(index, prefix)
+		if ok && t.Before(limit) && (previous == "" || t.After(latest)) {
+			previous = index
+			latest = t
+		}
+	}
+	return previous, previous != ""
+}
+
+// rollbackTarget works out which index to roll back to from current. If
+// current is in the promotion history, the target is the last index
+// promoted before it which is still among indexes, and the history to
+// keep ends with the target. Otherwise the target is the previous index
+// by name, which may never have been live, and the boolean is true to
+// show that it needs confirming.
+func rollbackTarget(prefix string, current string, history []string, indexes []string) (string, []string, bool, error) {
+	exists := make(map[string]bool)
+	for _, index := range indexes {
+		exists[index] = true
+	}
+	pos := -1
+	for i, index := range history {
+		if index == current {
+			pos = i
+		}
+	}
+	if pos >= 0 {
+		for i := pos - 1; i >= 0; i-- {
+			if history[i] != current && exists[history[i]] {
+				return history[i], history[:i+1], false, nil
+			}
+		}
+		return "", nil, false, fmt.Errorf("No index for %s promoted before %s still exists", prefix, current)
+	}
+	previous, ok := previousIndex(prefix, current, indexes)
+	if !ok {
+		return "", nil, false, fmt.Errorf("No index for %s is older than %s", prefix, current)
+	}
+	return previous, []string{previous}, true, nil
+}
+
+// Rollback replaces the current index for a prefix on the primary alias
+// with the one promoted before it, using the same atomic alias actions as
+// Promote, and takes the current index out of the promotion history. It
+// returns the index which was current and the one which replaced it.
+//
+// If the current index is not in the history, such as when it was
+// promoted before the history was kept, the newest index older than it by
+// the timestamp in its name is used instead. As that index may never have
+// been live, confirm is asked about it first, and ErrNotConfirmed is
+// returned if it declines.
+func (c ESClient) Rollback(prefix string, confirm func(index string) bool) (string, string, error) {
+	current, err := c.Current(prefix)
+	if err != nil {
+		return "", "", err
+	}
+	if current == "" {
+		return "", "", fmt.Errorf("No index for %s is linked to %s", prefix, c.primary())
+	}
+	res, err := c.client.Aliases().Index(prefix + "-*").Do(context.Background())
+	if err != nil {
+		return "", "", err
+	}
+	var indexes []string
+	for index := range res.Indices {
+		indexes = append(indexes, index)
+	}
+	history, err := c.History(prefix)
+	if err != nil {
+		return "", "", err
+	}
+	previous, keep, unconfirmed, err := rollbackTarget(prefix, current, history, indexes)
+	if err != nil {
+		return "", "", err
+	}
+	if unconfirmed && !confirm(previous) {
+		return current, previous, fmt.Errorf("%w: %s is not in the promotion history", ErrNotConfirmed, previous)
+	}
+	err = c.promote(previous, prefix)
+	if err != nil {
+		return "", "", err
+	}
+	err = c.saveHistory(prefix, keep)
+	if err != nil {
+		err = fmt.Errorf("%w for %s: %v", ErrHistory, previous, err)
+	}
+	return current, previous, err
+}
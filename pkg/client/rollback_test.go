@@ -0,0 +1,65 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPreviousIndex(t *testing.T) {
+	indexes := []string{
+		"aleph-2020-01-01t00-00-00z",
+		"aleph-2020-03-01t00-00-00z",
+		"aleph-2020-02-01t00-00-00z",
+		"aleph-2020-04-01t00-00-00z",
+		"aleph-test",
+	}
+	previous, ok := previousIndex("aleph", "aleph-2020-03-01t00-00-00z", indexes)
+	if !ok || previous != "aleph-2020-02-01t00-00-00z" {
+		t.Error("Expected aleph-2020-02-01t00-00-00z, got", previous)
+	}
+	if _, ok := previousIndex("aleph", "aleph-2020-01-01t00-00-00z", indexes); ok {
+		t.Error("Expected no index older than the oldest")
+	}
+	if _, ok := previousIndex("aleph", "aleph-test", indexes); ok {
+		t.Error("Expected no previous index without a timestamp")
+	}
+}
+
+func TestRollbackTarget(t *testing.T) {
+	indexes := []string{
+		"aleph-2020-01-01t00-00-00z",
+		"aleph-2020-02-01t00-00-00z",
+		"aleph-2020-03-01t00-00-00z",
+		"aleph-2020-04-01t00-00-00z",
+	}
+	// aleph-2020-03-01 failed the gate and was never promoted.
+	history := []string{
+		"aleph-2019-12-01t00-00-00z",
+		"aleph-2020-01-01t00-00-00z",
+		"aleph-2020-02-01t00-00-00z",
+		"aleph-2020-04-01t00-00-00z",
+	}
+	target, keep, confirm, err := rollbackTarget("aleph", "aleph-2020-04-01t00-00-00z", history, indexes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != "aleph-2020-02-01t00-00-00z" || confirm {
+		t.Error("Expected the last promoted index, got", target, confirm)
+	}
+	if !reflect.DeepEqual(keep, history[:3]) {
+		t.Error("Expected the history up to the target, got", keep)
+	}
+
+	_, _, _, err = rollbackTarget("aleph", "aleph-2020-01-01t00-00-00z", history, indexes)
+	if err == nil {
+		t.Error("Expected an error when the index promoted before no longer exists")
+	}
+
+	target, keep, confirm, err = rollbackTarget("aleph", "aleph-2020-04-01t00-00-00z", nil, indexes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != "aleph-2020-03-01t00-00-00z" || !confirm || len(keep) != 1 || keep[0] != target {
+		t.Error("Expected the previous index by name to need confirming, got", target, confirm, keep)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
 // GetS3Obj returns an io.ReadCloser for an S3 object.
@@ -33,3 +34,75 @@ func GetS3Obj(bucket string, key string) (io.ReadCloser, error) {
 	return result.Body, err
 
 }
+
+// s3Writer streams writes to an S3 object. The upload is complete once
+// the writer has been closed.
+type s3Writer struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+func (w *s3Writer) Close() error {
+	w.pw.Close()
+	return <-w.done
+}
+
+// PutS3Obj returns an io.WriteCloser for writing an S3 object. The
+// object is uploaded as it is written and Close returns any upload error.
+func PutS3Obj(bucket string, key string) (io.WriteCloser, error) {
+	sess, err := session.NewSession(&aws.Config{
+		Region: aws.String("us-east-1")},
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	uploader := s3manager.NewUploader(sess)
+	pr, pw := io.Pipe()
+	w := &s3Writer{pw: pw, done: make(chan error, 1)}
+
+	go func() {
+		_, err := uploader.Upload(&s3manager.UploadInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Body:   pr,
+		})
+		pr.CloseWithError(err)
+		w.done <- err
+	}()
+
+	return w, nil
+}
+
+// ListS3Objs returns the keys of the objects in a bucket which start with
+// prefix.
+func ListS3Objs(bucket string, prefix string) ([]string, error) {
+	sess, err := session.NewSession(&aws.Config{
+		Region: aws.String("us-east-1")},
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	svc := s3.New(sess)
+
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}
+
+	var keys []string
+	err = svc.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, obj := range page.Contents {
+			keys = append(keys, aws.StringValue(obj.Key))
+		}
+		return true
+	})
+	return keys, err
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/olivere/elastic"
+)
+
+// repositorySettings returns the settings for a snapshot repository. For
+// an fs repository location is a directory on a filesystem shared by
+// every node, which must be listed in their path.repo setting. For an s3
+// repository it is the name of the bucket.
+func repositorySettings(typ string, location string) (map[string]interface{}, error) {
+	switch typ {
+	case "fs":
+		return map[string]interface{}{"location": location}, nil
+	case "s3":
+		return map[string]interface{}{"bucket": location}, nil
+	}
+	return nil, fmt.Errorf("Unknown repository type %s", typ)
+}
+
+// SnapshotName returns a name for a snapshot taken at t, made from name
+// and the time.
+func SnapshotName(name string, t time.Time) string {
+	return name + "-" + t.UTC().Format(IndexTimeFormat)
+}
+
+// CreateRepository registers a snapshot repository of type fs or s3, or
+// updates its settings if it already exists.
+func (c ESClient) CreateRepository(name string, typ string, location string) error {
+	settings, err := repositorySettings(typ, location)
+	if err != nil {
+		return err
+	}
+	_, err = c.client.
+		SnapshotCreateRepository(name).
+		Type(typ).
+		Settings(settings).
+		Do(context.Background())
+	return err
+}
+
+// Snapshot takes a snapshot of the given indexes in a repository, waiting
+// for it to finish. An error is returned unless every shard was saved.
+func (c ESClient) Snapshot(repository string, name string, indexes ...string) (*elastic.Snapshot, error) {
+	res, err := c.client.
+		SnapshotCreate(repository, name).
+		BodyJson(map[string]interface{}{
+			"indices":              strings.Join(indexes, ","),
+			"include_global_state": false,
+		}).
+		WaitForCompletion(true).
+		Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if res.Snapshot == nil {
+		return nil, fmt.Errorf("No snapshot in response for %s", name)
+	}
+	if res.Snapshot.State != "SUCCESS" {
+		return res.Snapshot, fmt.Errorf("Snapshot %s finished with state %s", name, res.Snapshot.State)
+	}
+	return res.Snapshot, nil
+}
+
+// Snapshots lists the snapshots in a repository.
+func (c ESClient) Snapshots(repository string) ([]*elastic.Snapshot, error) {
+	res, err := c.client.
+		SnapshotGet(repository).
+		Snapshot("_all").
+		Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return res.Snapshots, nil
+}
+
+// Restore an index from a snapshot under a new name, waiting for it to
+// finish. Aliases are not restored, so the new index can be checked
+// before it is promoted.
+func (c ESClient) Restore(repository string, snapshot string, index string, name string) error {
+	_, err := c.client.
+		SnapshotRestore(repository, snapshot).
+		Indices(index).
+		RenamePattern("^" + regexp.QuoteMeta(index) + "$").
+		RenameReplacement(name).
+		IncludeAliases(false).
+		IncludeGlobalState(false).
+		WaitForCompletion(true).
+		Do(context.Background())
+	return err
+}
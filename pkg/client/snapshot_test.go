@@ -0,0 +1,34 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRepositorySettings(t *testing.T) {
+	settings, err := repositorySettings("fs", "/mnt/snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings["location"] != "/mnt/snapshots" {
+		t.Error("Expected location setting, got", settings)
+	}
+	settings, err = repositorySettings("s3", "timdex-snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings["bucket"] != "timdex-snapshots" {
+		t.Error("Expected bucket setting, got", settings)
+	}
+	if _, err = repositorySettings("hdfs", "/snapshots"); err == nil {
+		t.Error("Expected an error for an unknown repository type")
+	}
+}
+
+func TestSnapshotName(t *testing.T) {
+	at := time.Date(2020, 4, 8, 14, 2, 53, 0, time.UTC)
+	name := SnapshotName("aleph-2020-04-01t00-00-00z", at)
+	if name != "aleph-2020-04-01t00-00-00z-2020-04-08t14-02-53z" {
+		t.Error("Unexpected name", name)
+	}
+}
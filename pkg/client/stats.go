@@ -0,0 +1,153 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+
+	"github.com/markbates/pkger"
+	"github.com/mitlibraries/mario/pkg/record"
+	"github.com/olivere/elastic"
+)
+
+// StatsFacets are the fields broken down by value in IndexStats.
+var StatsFacets = []string{"source", "content_type", "format", "languages"}
+
+// IndexStats summarises the documents in an index.
+type IndexStats struct {
+	Index    string
+	Total    int64
+	Facets   map[string][]Bucket
+	Coverage []Coverage
+}
+
+// Bucket is the number of documents with a value in a facet.
+type Bucket struct {
+	Value string
+	Count int64
+}
+
+// Coverage is the number of documents which have a value for a
+// record.Record field.
+type Coverage struct {
+	Field string
+	Count int64
+	Share float64
+}
+
+// RecordFields returns the JSON names of the record.Record fields, in the
+// order they are declared.
+func RecordFields() []string {
+	var fields []string
+	t := reflect.TypeOf(record.Record{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+// mappingsConfig returns the embedded index settings and mappings config.
+func mappingsConfig() (map[string]interface{}, error) {
+	file, err := pkger.Open("/config/es_record_mappings.json")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	b, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	var config map[string]interface{}
+	err = json.Unmarshal(b, &config)
+	return config, err
+}
+
+// recordMappings returns the properties of the Record type from the
+// embedded mappings config.
+func recordMappings() (map[string]interface{}, error) {
+	config, err := mappingsConfig()
+	if err != nil {
+		return nil, err
+	}
+	return lookup(config, "mappings", "Record", "properties"), nil
+}
+
+// lookup follows keys through nested JSON objects, returning nil if any
+// of them is missing.
+func lookup(v interface{}, keys ...string) map[string]interface{} {
+	for _, k := range keys {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[k]
+	}
+	m, _ := v.(map[string]interface{})
+	return m
+}
+
+// isNested reports whether a field has the nested type in mappings.
+func isNested(mappings map[string]interface{}, field string) bool {
+	m, ok := mappings[field].(map[string]interface{})
+	return ok && m["type"] == "nested"
+}
+
+// Stats counts the documents in an index by the values of each of the
+// StatsFacets, keeping the size most common values, and counts how many
+// documents fill each record.Record field.
+func (c ESClient) Stats(index string, size int) (*IndexStats, error) {
+	return stats(c.client.Search(index), index, size)
+}
+
+// stats runs the Stats aggregations with svc.
+func stats(svc *elastic.SearchService, index string, size int) (*IndexStats, error) {
+	mappings, err := recordMappings()
+	if err != nil {
+		return nil, err
+	}
+	svc.Size(0)
+	for _, f := range StatsFacets {
+		svc.Aggregation("facet_"+f, elastic.NewTermsAggregation().Field(f+".keyword").Size(size))
+	}
+	fields := RecordFields()
+	for _, f := range fields {
+		var q elastic.Query = elastic.NewExistsQuery(f)
+		if isNested(mappings, f) {
+			q = elastic.NewNestedQuery(f, elastic.NewMatchAllQuery())
+		}
+		svc.Aggregation("field_"+f, elastic.NewFilterAggregation().Filter(q))
+	}
+	res, err := svc.Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &IndexStats{Index: index, Total: res.TotalHits(), Facets: make(map[string][]Bucket)}
+	for _, f := range StatsFacets {
+		terms, ok := res.Aggregations.Terms("facet_" + f)
+		if !ok {
+			return nil, fmt.Errorf("Missing %s facet in response", f)
+		}
+		for _, b := range terms.Buckets {
+			stats.Facets[f] = append(stats.Facets[f], Bucket{Value: fmt.Sprint(b.Key), Count: b.DocCount})
+		}
+	}
+	for _, f := range fields {
+		filter, ok := res.Aggregations.Filter("field_" + f)
+		if !ok {
+			return nil, fmt.Errorf("Missing %s coverage in response", f)
+		}
+		cov := Coverage{Field: f, Count: filter.DocCount}
+		if stats.Total > 0 {
+			cov.Share = float64(filter.DocCount) / float64(stats.Total)
+		}
+		stats.Coverage = append(stats.Coverage, cov)
+	}
+	return stats, nil
+}
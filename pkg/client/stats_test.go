@@ -0,0 +1,20 @@
+package client
+
+import "testing"
+
+func TestRecordFields(t *testing.T) {
+	fields := RecordFields()
+	if fields[0] != "identifier" {
+		t.Error("Expected identifier, got", fields[0])
+	}
+	seen := make(map[string]bool)
+	for _, f := range fields {
+		seen[f] = true
+	}
+	if !seen["isbns"] || !seen["contributors"] {
+		t.Error("Expected isbns and contributors, got", fields)
+	}
+	if seen["-"] || seen["Deleted"] {
+		t.Error("Expected fields not sent to Elasticsearch to be left out, got", fields)
+	}
+}
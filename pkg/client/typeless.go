@@ -0,0 +1,123 @@
+package client
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/mitlibraries/mario/pkg/record"
+	"github.com/olivere/elastic"
+)
+
+// Cluster is an Indexer which can also report on its indexes. ESClient is
+// the Cluster for Elasticsearch 6, which has mapping types, and
+// TypelessClient the one for later versions of Elasticsearch and for
+// OpenSearch. Use Detect to pick the right one.
+type Cluster interface {
+	Indexer
+	Stats(string, int) (*IndexStats, error)
+	DiffMappings(string) (*MappingDiff, error)
+}
+
+// TypelessClient is an ESClient for clusters without mapping types, such
+// as Elasticsearch 7 and OpenSearch. Documents are indexed without a type,
+// whatever type they are added with, and indexes are created with the
+// mappings for record.Record at the top level.
+type TypelessClient struct {
+	*ESClient
+}
+
+// Detect pings the cluster at url and returns the Cluster which matches
+// its version.
+func (c *ESClient) Detect(url string) (Cluster, error) {
+	res, err := c.Ping(url)
+	if err != nil {
+		return nil, err
+	}
+	if typeless(res) {
+		return &TypelessClient{c}, nil
+	}
+	return c, nil
+}
+
+// typeless reports whether a cluster has dropped mapping types, which is
+// the case for OpenSearch and for Elasticsearch from version 7.
+func typeless(res *elastic.PingResult) bool {
+	if strings.Contains(res.TagLine, "OpenSearch") {
+		return true
+	}
+	major, err := strconv.Atoi(strings.SplitN(res.Version.Number, ".", 2)[0])
+	return err == nil && major >= 7
+}
+
+// typelessConfig returns the embedded mappings config with the mappings
+// for the Record type moved to the top level.
+func typelessConfig() (map[string]interface{}, error) {
+	config, err := mappingsConfig()
+	if err != nil {
+		return nil, err
+	}
+	config["mappings"] = lookup(config, "mappings", "Record")
+	return config, nil
+}
+
+// Create the new index if it does not exist.
+func (c *TypelessClient) Create(index string) error {
+	config, err := typelessConfig()
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return c.create(index, string(b))
+}
+
+// Add a record to the bulk processor. The type is ignored.
+func (c *TypelessClient) Add(record record.Record, index string, rtype string) error {
+	if c.bulker == nil {
+		return ErrNotStarted
+	}
+	doc, err := document(record)
+	if err != nil {
+		return err
+	}
+	d := elastic.NewBulkIndexRequest().
+		Index(index).
+		Id(record.Identifier).
+		Doc(doc)
+	c.bulker.Add(d)
+	return nil
+}
+
+// Remove a record from the index with the bulk processor. The type is
+// ignored.
+func (c *TypelessClient) Remove(id string, index string, rtype string) error {
+	if c.bulker == nil {
+		return ErrNotStarted
+	}
+	d := elastic.NewBulkDeleteRequest().
+		Index(index).
+		Id(id)
+	c.bulker.Add(d)
+	return nil
+}
+
+// Stats counts the documents in an index by the values of each of the
+// StatsFacets, and counts how many documents fill each record.Record
+// field. The total is requested as a number rather than the object these
+// clusters return by default.
+func (c *TypelessClient) Stats(index string, size int) (*IndexStats, error) {
+	return stats(c.client.Search(index).RestTotalHitsAsInt(true), index, size)
+}
+
+// DiffMappings compares the live mappings and analysis settings of an
+// index with the typeless form of the embedded mappings config.
+func (c *TypelessClient) DiffMappings(index string) (*MappingDiff, error) {
+	config, err := typelessConfig()
+	if err != nil {
+		return nil, err
+	}
+	return c.diffMappings(index, config, lookup(config, "mappings", "properties"))
+}
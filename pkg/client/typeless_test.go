@@ -0,0 +1,44 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/olivere/elastic"
+)
+
+func TestTypeless(t *testing.T) {
+	var tests = []struct {
+		number   string
+		tagline  string
+		expected bool
+	}{
+		{"6.8.0", "You Know, for Search", false},
+		{"7.10.2", "You Know, for Search", true},
+		{"8.1.0", "You Know, for Search", true},
+		{"1.3.0", "The OpenSearch Project: https://opensearch.org/", true},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		res := &elastic.PingResult{TagLine: tt.tagline}
+		res.Version.Number = tt.number
+		if typeless(res) != tt.expected {
+			t.Errorf("Expected %v for %s, got %v", tt.expected, tt.number, !tt.expected)
+		}
+	}
+}
+
+func TestTypelessConfig(t *testing.T) {
+	config, err := typelessConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lookup(config, "mappings", "Record") != nil {
+		t.Error("Expected no Record type in mappings")
+	}
+	if lookup(config, "mappings", "properties", "title") == nil {
+		t.Error("Expected title in top level properties")
+	}
+	if lookup(config, "settings", "analysis") == nil {
+		t.Error("Expected analysis settings")
+	}
+}
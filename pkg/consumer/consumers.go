@@ -1,75 +1,182 @@
 package consumer
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"sync"
+	"sync/atomic"
 
 	"github.com/mitlibraries/mario/pkg/client"
+	"github.com/mitlibraries/mario/pkg/pipeline"
 	"github.com/mitlibraries/mario/pkg/record"
 )
 
-//ESConsumer adds Records to ElasticSearch.
+//ESConsumer adds Records to ElasticSearch. Deleted Records are removed
+//from the index instead. A Record which cannot be encoded is reported as
+//a RecordError, and any other error from the Client is fatal.
+//
+//If Track is set, the identifier of each Record handed to the Client is
+//kept along with its position, counting from zero, until it is taken
+//with Take. This lets documents which later fail in bulk be traced back
+//to where they were in the input.
 type ESConsumer struct {
-	Index  string
-	RType  string
-	Client client.Indexer
+	Index   string
+	RType   string
+	Client  client.Indexer
+	Track   bool
+	added   int64
+	mu      sync.Mutex
+	pending map[string]int
 }
 
 //Consume the records.
-func (es *ESConsumer) Consume(in <-chan record.Record) <-chan bool {
+func (es *ESConsumer) Consume(ctx context.Context, in <-chan record.Record, errs chan<- error) <-chan bool {
 	out := make(chan bool)
 	go func() {
+		var failed bool
 		for r := range in {
-			es.Client.Add(r, es.Index, es.RType)
+			if failed {
+				continue
+			}
+			var err error
+			if r.Deleted {
+				err = es.Client.Remove(r.Identifier, es.Index, es.RType)
+			} else {
+				err = es.Client.Add(r, es.Index, es.RType)
+			}
+			if errors.Is(err, client.ErrEncode) {
+				errs <- &pipeline.RecordError{Identifier: r.Identifier, Reason: pipeline.ReasonEncode, Err: err}
+			} else if err != nil {
+				errs <- fmt.Errorf("Could not add %s to %s: %w", r.Identifier, es.Index, err)
+				failed = true
+				continue
+			} else if es.Track {
+				es.track(r.Identifier)
+				continue
+			}
+			atomic.AddInt64(&es.added, 1)
 		}
 		close(out)
 	}()
 	return out
 }
 
+//Added returns the number of Records handed to the Client so far,
+//including deleted Records. It is safe to call while records are being
+//consumed.
+func (es *ESConsumer) Added() int {
+	return int(atomic.LoadInt64(&es.added))
+}
+
+// track keeps the position of a Record handed to the Client and counts
+// it as added.
+func (es *ESConsumer) track(id string) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if es.pending == nil {
+		es.pending = make(map[string]int)
+	}
+	es.pending[id] = int(atomic.LoadInt64(&es.added))
+	atomic.AddInt64(&es.added, 1)
+}
+
+//Take returns the positions of the tracked Records, by identifier, which
+//were among the first n added, and stops tracking them.
+func (es *ESConsumer) Take(n int) map[string]int {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	taken := make(map[string]int)
+	for id, pos := range es.pending {
+		if pos < n {
+			taken[id] = pos
+			delete(es.pending, id)
+		}
+	}
+	return taken
+}
+
 //JSONConsumer outputs Records as JSON. The Records will be written
-//to JSONConsumer.out.
+//to JSONConsumer.out. Deleted Records are skipped. A Record which cannot
+//be encoded is reported as a RecordError, and failing to write is fatal.
+//
+//Records from every call to Consume are written to the same JSON array,
+//which is ended by Close.
 type JSONConsumer struct {
-	Out io.Writer
+	Out    io.Writer
+	opened bool
+	n      int
+	err    error
 }
 
 //Consume the records.
-func (js *JSONConsumer) Consume(in <-chan record.Record) <-chan bool {
+func (js *JSONConsumer) Consume(ctx context.Context, in <-chan record.Record, errs chan<- error) <-chan bool {
 	out := make(chan bool)
 	go func() {
-		fmt.Fprintln(js.Out, "[")
-		var i int
+		failed := js.err != nil
+		if !js.opened && !failed {
+			_, js.err = fmt.Fprintln(js.Out, "[")
+			js.opened = true
+		}
 		for r := range in {
+			if r.Deleted || js.err != nil {
+				continue
+			}
 			b, err := json.MarshalIndent(r, "", "    ")
 			if err != nil {
-				log.Println(err)
+				errs <- &pipeline.RecordError{Identifier: r.Identifier, Reason: pipeline.ReasonEncode, Err: err}
+				continue
 			}
-			if i != 0 {
-				fmt.Fprintln(js.Out, ",")
+			if js.n != 0 {
+				_, js.err = fmt.Fprintln(js.Out, ",")
 			}
-			fmt.Fprintln(js.Out, string(b))
-			i++
+			if js.err == nil {
+				_, js.err = fmt.Fprintln(js.Out, string(b))
+			}
+			js.n++
+		}
+		if js.err != nil && !failed {
+			errs <- fmt.Errorf("Could not write records: %w", js.err)
 		}
-		fmt.Fprintln(js.Out, "]")
 		close(out)
 	}()
 	return out
 }
 
+//Close ends the array of Records. It should only be called once every
+//Record has been consumed, and returns any error from writing them.
+func (js *JSONConsumer) Close() error {
+	if js.err == nil && !js.opened {
+		_, js.err = fmt.Fprintln(js.Out, "[")
+		js.opened = true
+	}
+	if js.err == nil {
+		_, js.err = fmt.Fprintln(js.Out, "]")
+	}
+	return js.err
+}
+
 //TitleConsumer just outputs the title of Records. The titles will be
-//written to TitleConsumer.out.
+//written to TitleConsumer.out. Deleted Records are skipped. Failing to
+//write is fatal.
 type TitleConsumer struct {
 	Out io.Writer
 }
 
 //Consume the records.
-func (t *TitleConsumer) Consume(in <-chan record.Record) <-chan bool {
+func (t *TitleConsumer) Consume(ctx context.Context, in <-chan record.Record, errs chan<- error) <-chan bool {
 	out := make(chan bool)
 	go func() {
+		var err error
 		for r := range in {
-			fmt.Fprintln(t.Out, r.Title)
+			if !r.Deleted && err == nil {
+				_, err = fmt.Fprintln(t.Out, r.Title)
+			}
+		}
+		if err != nil {
+			errs <- fmt.Errorf("Could not write titles: %w", err)
 		}
 		close(out)
 	}()
@@ -82,7 +189,7 @@ type SilentConsumer struct {
 }
 
 //Consume the records and close the channel when done. No processing is done.
-func (s *SilentConsumer) Consume(in <-chan record.Record) <-chan bool {
+func (s *SilentConsumer) Consume(ctx context.Context, in <-chan record.Record, errs chan<- error) <-chan bool {
 	out := make(chan bool)
 	go func() {
 		for range in {
@@ -2,7 +2,12 @@ package consumer
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mitlibraries/mario/pkg/client"
+	"github.com/mitlibraries/mario/pkg/pipeline"
 	"github.com/mitlibraries/mario/pkg/record"
 	"strings"
 	"testing"
@@ -12,7 +17,7 @@ func TestTitleConsumerConsume(t *testing.T) {
 	var b bytes.Buffer
 	in := make(chan record.Record)
 	c := TitleConsumer{Out: &b}
-	out := c.Consume(in)
+	out := c.Consume(context.Background(), in, nil)
 	in <- record.Record{Title: "Hatsopoulos Microfluids"}
 	close(in)
 	<-out
@@ -26,10 +31,13 @@ func TestTitleJsonConsume(t *testing.T) {
 	var b bytes.Buffer
 	in := make(chan record.Record)
 	c := JSONConsumer{Out: &b}
-	out := c.Consume(in)
+	out := c.Consume(context.Background(), in, nil)
 	in <- record.Record{Title: "Hatsopoulos Microfluids"}
 	close(in)
 	<-out
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
 
 	var records []*record.Record
 	json.NewDecoder(&b).Decode(&records)
@@ -38,3 +46,158 @@ func TestTitleJsonConsume(t *testing.T) {
 		t.Error("Expected match, got", records[0].Title)
 	}
 }
+
+func TestJSONConsumerWritesOneArray(t *testing.T) {
+	var b bytes.Buffer
+	c := JSONConsumer{Out: &b}
+	for _, title := range []string{"Microfluids", "Nanofluids"} {
+		in := make(chan record.Record)
+		out := c.Consume(context.Background(), in, nil)
+		in <- record.Record{Title: title}
+		close(in)
+		<-out
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var records []*record.Record
+	if err := json.Unmarshal(b.Bytes(), &records); err != nil {
+		t.Fatal("Expected a single JSON array, got", b.String())
+	}
+	if len(records) != 2 || records[1].Title != "Nanofluids" {
+		t.Error("Expected both records, got", records)
+	}
+
+	b.Reset()
+	c = JSONConsumer{Out: &b}
+	if err := c.Close(); err != nil || strings.Join(strings.Fields(b.String()), "") != "[]" {
+		t.Error("Expected an empty array, got", b.String(), err)
+	}
+}
+
+func TestRejectWriterReject(t *testing.T) {
+	var data, reasons bytes.Buffer
+	w := RejectWriter{Data: &data, Reasons: &reasons}
+	w.Reject(&pipeline.RecordError{
+		Identifier: "1",
+		Reason:     pipeline.ReasonNoTitle,
+		Raw:        []byte("foo"),
+		Err:        errors.New("Record 1 has no title"),
+	})
+	w.Reject(&pipeline.RecordError{
+		Identifier: "2",
+		Reason:     pipeline.ReasonParse,
+		Raw:        []byte("barbaz"),
+		Err:        errors.New("Record 2 is broken"),
+	})
+
+	if data.String() != "foobarbaz" {
+		t.Error("Expected match, got", data.String())
+	}
+
+	var r struct {
+		Identifier string
+		Reason     string
+		Offset     int
+		Length     int
+	}
+	d := json.NewDecoder(&reasons)
+	d.Decode(&r)
+	d.Decode(&r)
+	if r.Identifier != "2" || r.Reason != "parse_error" || r.Offset != 3 || r.Length != 6 {
+		t.Error("Expected match, got", r)
+	}
+}
+
+type fakeIndexer struct {
+	client.Indexer
+	added   []string
+	removed []string
+	errs    map[string]error
+}
+
+func (f *fakeIndexer) Add(r record.Record, index string, rtype string) error {
+	if err := f.errs[r.Identifier]; err != nil {
+		return err
+	}
+	f.added = append(f.added, r.Identifier)
+	return nil
+}
+
+func (f *fakeIndexer) Remove(id string, index string, rtype string) error {
+	if err := f.errs[id]; err != nil {
+		return err
+	}
+	f.removed = append(f.removed, id)
+	return nil
+}
+
+func TestESConsumerRemovesDeleted(t *testing.T) {
+	f := &fakeIndexer{}
+	in := make(chan record.Record)
+	c := ESConsumer{Index: "aleph", RType: "Record", Client: f}
+	out := c.Consume(context.Background(), in, nil)
+	in <- record.Record{Identifier: "001", Title: "Hatsopoulos Microfluids"}
+	in <- record.Record{Identifier: "002", Deleted: true}
+	close(in)
+	<-out
+	if len(f.added) != 1 || f.added[0] != "001" {
+		t.Error("Expected 001 to be added, got", f.added)
+	}
+	if len(f.removed) != 1 || f.removed[0] != "002" {
+		t.Error("Expected 002 to be removed, got", f.removed)
+	}
+	if c.Added() != 2 {
+		t.Error("Expected 2, got", c.Added())
+	}
+}
+
+func TestESConsumerReportsErrors(t *testing.T) {
+	f := &fakeIndexer{errs: map[string]error{
+		"002": fmt.Errorf("%w 002: bad value", client.ErrEncode),
+		"003": client.ErrNotStarted,
+	}}
+	in := make(chan record.Record)
+	errs := make(chan error, 3)
+	c := ESConsumer{Index: "aleph", RType: "Record", Client: f}
+	out := c.Consume(context.Background(), in, errs)
+	for _, id := range []string{"001", "002", "003", "004"} {
+		in <- record.Record{Identifier: id, Title: "Hatsopoulos Microfluids"}
+	}
+	close(in)
+	<-out
+	close(errs)
+	var re *pipeline.RecordError
+	if err := <-errs; !errors.As(err, &re) || re.Identifier != "002" || re.Reason != pipeline.ReasonEncode {
+		t.Error("Expected an encode_error for 002, got", err)
+	}
+	if err := <-errs; !errors.Is(err, client.ErrNotStarted) {
+		t.Error("Expected a fatal error, got", err)
+	}
+	if err, ok := <-errs; ok {
+		t.Error("Expected only the first fatal error, got", err)
+	}
+	if len(f.added) != 1 || f.added[0] != "001" {
+		t.Error("Expected only 001 to be added, got", f.added)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestJSONConsumerReportsWriteErrors(t *testing.T) {
+	in := make(chan record.Record)
+	errs := make(chan error, 1)
+	c := JSONConsumer{Out: failingWriter{}}
+	out := c.Consume(context.Background(), in, errs)
+	in <- record.Record{Title: "Hatsopoulos Microfluids"}
+	in <- record.Record{Title: "Hatsopoulos Microfluids"}
+	close(in)
+	<-out
+	if err := <-errs; err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Error("Expected a write error, got", err)
+	}
+}
@@ -0,0 +1,48 @@
+package consumer
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/mitlibraries/mario/pkg/pipeline"
+)
+
+//RejectWriter writes rejected records to a dead-letter output. The raw
+//records are written unchanged to Data, so a file of rejects can be fixed
+//and ingested again. A JSON line describing each rejection is written to
+//Reasons. The offset and length in each line locate the raw record in
+//Data.
+type RejectWriter struct {
+	Data    io.Writer
+	Reasons io.Writer
+	offset  int64
+}
+
+type rejection struct {
+	Identifier string `json:"identifier"`
+	Reason     string `json:"reason"`
+	Error      string `json:"error"`
+	Offset     int64  `json:"offset"`
+	Length     int    `json:"length"`
+}
+
+//Reject writes a single rejected record.
+func (w *RejectWriter) Reject(e *pipeline.RecordError) error {
+	n, err := w.Data.Write(e.Raw)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(rejection{
+		Identifier: e.Identifier,
+		Reason:     e.Reason,
+		Error:      e.Error(),
+		Offset:     w.offset,
+		Length:     n,
+	})
+	if err != nil {
+		return err
+	}
+	w.offset += int64(n)
+	_, err = w.Reasons.Write(append(b, '\n'))
+	return err
+}
@@ -1,61 +1,118 @@
 package generator
 
 import (
+	"context"
 	"encoding/xml"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"strings"
 
 	"github.com/antchfx/xmlquery"
 	"github.com/markbates/pkger"
+	"github.com/mitlibraries/mario/pkg/pipeline"
 	"github.com/mitlibraries/mario/pkg/record"
 	yaml "gopkg.in/yaml.v2"
 )
 
 type archivesparser struct {
-	file io.Reader
+	file  io.Reader
+	codes AspaceCodesMap
+	skip  int
 }
 
-// ArchivesGenerator parses archivespace ead xml data.
+// newArchivesparser loads the ArchivesSpace code mappings needed to parse
+// EAD.
+func newArchivesparser(file io.Reader) (*archivesparser, error) {
+	codes, err := retrieveAspaceCodes("/config/aspace_code_mappings.yml")
+	if err != nil {
+		return nil, fmt.Errorf("Could not load ArchivesSpace code mappings: %w", err)
+	}
+	return &archivesparser{file: file, codes: codes}, nil
+}
+
+// ArchivesGenerator parses archivespace ead xml data. The first Skip
+// records in the file are read but not mapped.
 type ArchivesGenerator struct {
 	Archivefile io.Reader
+	Skip        int
 	rulesfile   string
 }
 
-// Generate a channel of Records.
-func (m *ArchivesGenerator) Generate() <-chan record.Record {
+// Generate a channel of Records. The channel is closed early if the
+// context is cancelled. Failure to load the code mappings or to read the
+// XML stream is sent as a fatal error.
+func (m *ArchivesGenerator) Generate(ctx context.Context, errs chan<- error) <-chan record.Record {
 	out := make(chan record.Record)
-	p := archivesparser{file: m.Archivefile}
-	go p.parse(out)
+	p, err := newArchivesparser(m.Archivefile)
+	if err != nil {
+		go fail(errs, out, err)
+		return out
+	}
+	p.skip = m.Skip
+	go p.parse(ctx, out, errs)
 	return out
 }
 
 // Streams the xml file and kicks off processing for each record found
-func (m *archivesparser) parse(out chan record.Record) {
-	decoder := xml.NewDecoder(m.file)
+func (m *archivesparser) parse(ctx context.Context, out chan record.Record, errs chan<- error) {
+	defer close(out)
+	raw := newRecorder(m.file)
+	decoder := xml.NewDecoder(raw)
+	var n int
 
-	for {
+	for ctx.Err() == nil {
 		// Read tokens from the XML document in a stream.
-		t, _ := decoder.Token()
-		if t == nil {
+		raw.mark(decoder.InputOffset())
+		t, err := decoder.Token()
+		if err == io.EOF {
 			break
 		}
+		if err != nil {
+			errs <- fmt.Errorf("Error reading EAD file: %w", err)
+			return
+		}
 		// Inspect the type of the token just read.
 		switch se := t.(type) {
 		case xml.StartElement:
 			// If we just read a StartElement token named "record"
 			if se.Name.Local == "record" {
-				processXMLRecord(se, decoder, out)
+				n++
+				if n <= m.skip {
+					if err := decoder.Skip(); err != nil {
+						errs <- fmt.Errorf("Error reading EAD file: %w", err)
+						return
+					}
+					continue
+				}
+				r, err := m.processXMLRecord(se, decoder)
+				var serr *xml.SyntaxError
+				if errors.As(err, &serr) {
+					errs <- fmt.Errorf("Error reading EAD file: %w", err)
+					return
+				} else if err != nil {
+					var re *pipeline.RecordError
+					if !errors.As(err, &re) {
+						re = &pipeline.RecordError{Identifier: r.Identifier, Reason: pipeline.ReasonParse, Err: err}
+					}
+					re.Raw = append(raw.since(decoder.InputOffset()), '\n')
+					errs <- re
+				} else {
+					send(ctx, out, r)
+				}
 			}
 		}
 	}
-	close(out)
 }
 
 // processXMLRecord handles the mapping from EAD to Record. More complex mappings split out into funcs
-func processXMLRecord(se xml.StartElement, decoder *xml.Decoder, out chan record.Record) {
+func (m *archivesparser) processXMLRecord(se xml.StartElement, decoder *xml.Decoder) (record.Record, error) {
 	var ar AspaceRecord
-	decoder.DecodeElement(&ar, &se)
+	err := decoder.DecodeElement(&ar, &se)
+	if err != nil {
+		return record.Record{}, err
+	}
 
 	r := record.Record{}
 
@@ -67,7 +124,7 @@ func processXMLRecord(se xml.StartElement, decoder *xml.Decoder, out chan record
 
 	// Contributor field
 	if len(ar.Metadata.Ead.Archdesc.Did.Origination) > 0 {
-		r.Contributor = eadContributors(ar)
+		r.Contributor = eadContributors(ar, m.codes)
 	}
 
 	//  Holdings field
@@ -77,6 +134,9 @@ func processXMLRecord(se xml.StartElement, decoder *xml.Decoder, out chan record
 
 	// Identifier field
 	r.Identifier = "MIT:archivesspace:" + strings.Replace(ar.Metadata.Ead.Archdesc.Did.Unitid, " ", ".", -1)
+	if !strings.Contains(ar.Header.Identifier, "oai:mit/") {
+		return r, rejection(r.Identifier, pipeline.ReasonIdentifier, "Record %s has an unexpected OAI identifier: %q", r.Identifier, ar.Header.Identifier)
+	}
 
 	// Language field
 	if len(ar.Metadata.Ead.Archdesc.Did.Langmaterial) > 0 {
@@ -118,7 +178,7 @@ func processXMLRecord(se xml.StartElement, decoder *xml.Decoder, out chan record
 	// Title field
 	r.Title = ar.Metadata.Ead.Archdesc.Did.Unittitle.Text
 
-	out <- r
+	return r, nil
 }
 
 // AspaceCodesMap defines codes for parsing ASpace record fields
@@ -128,23 +188,26 @@ type AspaceCodesMap struct {
 	} `yaml:"enumerations"`
 }
 
-func eadContributors(ar AspaceRecord) []*record.Contributor {
-	var contribs []*record.Contributor
+// retrieveAspaceCodes loads the ArchivesSpace code mappings.
+func retrieveAspaceCodes(filePath string) (AspaceCodesMap, error) {
 	var codes AspaceCodesMap
 
-	file, err := pkger.Open("/config/aspace_code_mappings.yml")
+	file, err := pkger.Open(filePath)
 	if err != nil {
-		panic(err)
+		return codes, err
 	}
+	defer file.Close()
 	yamlFile, err := ioutil.ReadAll(file)
 	if err != nil {
-		panic(err)
+		return codes, err
 	}
 
 	err = yaml.Unmarshal(yamlFile, &codes)
-	if err != nil {
-		panic(err)
-	}
+	return codes, err
+}
+
+func eadContributors(ar AspaceRecord, codes AspaceCodesMap) []*record.Contributor {
+	var contribs []*record.Contributor
 
 	for _, c := range ar.Metadata.Ead.Archdesc.Did.Origination {
 		contrib := new(record.Contributor)
@@ -1,8 +1,12 @@
 package generator
 
 import (
+	"context"
+	"errors"
+	"github.com/mitlibraries/mario/pkg/pipeline"
 	"github.com/mitlibraries/mario/pkg/record"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -13,8 +17,11 @@ func TestArchivesProcessesAllRecords(t *testing.T) {
 	}
 
 	out := make(chan record.Record)
-	p := archivesparser{file: ead}
-	go p.parse(out)
+	p, err := newArchivesparser(ead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.parse(context.Background(), out, discard())
 
 	var chanLength int
 	for range out {
@@ -33,8 +40,11 @@ func TestArchivesRecordParsing(t *testing.T) {
 	}
 
 	out := make(chan record.Record)
-	p := archivesparser{file: ead}
-	go p.parse(out)
+	p, err := newArchivesparser(ead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.parse(context.Background(), out, discard())
 
 	record := <-out
 
@@ -126,3 +136,32 @@ func TestArchivesRecordParsing(t *testing.T) {
 		t.Error("Expected match, got", record.Title)
 	}
 }
+
+func TestArchivesRejectsKeepRawXML(t *testing.T) {
+	bad := `<record><header><identifier>oai:elsewhere/1</identifier></header>` +
+		`<metadata><ead><archdesc level="collection"><did><unitid>MC 1</unitid>` +
+		`<unittitle>Papers</unittitle></did></archdesc></ead></metadata></record>`
+	ead := strings.NewReader(`<?xml version="1.0"?>` + "\n<OAI-PMH>\n  " + bad + "\n</OAI-PMH>\n")
+
+	p, err := newArchivesparser(ead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(chan record.Record)
+	errs := make(chan error, 1)
+	go p.parse(context.Background(), out, errs)
+	for range out {
+		t.Error("Expected no records")
+	}
+
+	var re *pipeline.RecordError
+	if !errors.As(<-errs, &re) {
+		t.Fatal("Expected a RecordError")
+	}
+	if re.Reason != pipeline.ReasonIdentifier {
+		t.Error("Expected match, got", re.Reason)
+	}
+	if string(re.Raw) != bad+"\n" {
+		t.Error("Expected match, got", string(re.Raw))
+	}
+}
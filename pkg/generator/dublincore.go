@@ -0,0 +1,265 @@
+package generator
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/mitlibraries/mario/pkg/pipeline"
+	"github.com/mitlibraries/mario/pkg/record"
+)
+
+// dspaceHandleURL is where DSpace@MIT shows the item for a handle.
+const dspaceHandleURL = "https://dspace.mit.edu/handle/"
+
+// DublinCoreGenerator parses OAI-PMH records from DSpace@MIT with simple
+// (oai_dc) or qualified (qdc) Dublin Core metadata. Records deleted at
+// the source are generated with Deleted set. The first Skip records in
+// the file are read but not mapped.
+type DublinCoreGenerator struct {
+	File io.Reader
+	Skip int
+}
+
+// Generate a channel of Records. The channel is closed early if the
+// context is cancelled. Failure to read the XML stream is sent as a fatal
+// error.
+func (m *DublinCoreGenerator) Generate(ctx context.Context, errs chan<- error) <-chan record.Record {
+	out := make(chan record.Record)
+	go m.parse(ctx, out, errs)
+	return out
+}
+
+// parse streams the xml file and maps each record element found.
+func (m *DublinCoreGenerator) parse(ctx context.Context, out chan record.Record, errs chan<- error) {
+	defer close(out)
+	raw := newRecorder(m.File)
+	decoder := xml.NewDecoder(raw)
+	var n int
+
+	for ctx.Err() == nil {
+		raw.mark(decoder.InputOffset())
+		t, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			errs <- fmt.Errorf("Error reading Dublin Core file: %w", err)
+			return
+		}
+		se, ok := t.(xml.StartElement)
+		if !ok || se.Name.Local != "record" {
+			continue
+		}
+		n++
+		if n <= m.Skip {
+			if err := decoder.Skip(); err != nil {
+				errs <- fmt.Errorf("Error reading Dublin Core file: %w", err)
+				return
+			}
+			continue
+		}
+		var dc dcRecord
+		err = decoder.DecodeElement(&dc, &se)
+		if err != nil {
+			errs <- fmt.Errorf("Error reading Dublin Core file: %w", err)
+			return
+		}
+		r, err := dc.record()
+		if err != nil {
+			var re *pipeline.RecordError
+			if !errors.As(err, &re) {
+				re = &pipeline.RecordError{Identifier: r.Identifier, Reason: pipeline.ReasonParse, Err: err}
+			}
+			re.Raw = append(raw.since(decoder.InputOffset()), '\n')
+			errs <- re
+			continue
+		}
+		if !send(ctx, out, r) {
+			return
+		}
+	}
+}
+
+// dcRecord is an OAI-PMH record element. Elements are matched by name
+// alone, so the dc and dcterms elements of qualified Dublin Core are
+// read the same way as those of oai_dc.
+type dcRecord struct {
+	Header struct {
+		Identifier string `xml:"identifier"`
+		Status     string `xml:"status,attr"`
+	} `xml:"header"`
+	Metadata struct {
+		DC dcElements `xml:",any"`
+	} `xml:"metadata"`
+}
+
+// dcElements are the Dublin Core elements mario maps.
+type dcElements struct {
+	Title       []string `xml:"title"`
+	Alternative []string `xml:"alternative"`
+	Creator     []string `xml:"creator"`
+	Contributor []string `xml:"contributor"`
+	Subject     []string `xml:"subject"`
+	Date        []string `xml:"date"`
+	Issued      []string `xml:"issued"`
+	Identifier  []string `xml:"identifier"`
+	Rights      []string `xml:"rights"`
+	Type        []string `xml:"type"`
+	Description []string `xml:"description"`
+	Abstract    []string `xml:"abstract"`
+	Publisher   []string `xml:"publisher"`
+}
+
+// record maps the element to a Record.
+func (dc dcRecord) record() (record.Record, error) {
+	r := record.Record{}
+	d := dc.Metadata.DC
+
+	// Identifier field
+	handle := dcHandle(d.Identifier)
+	if handle == "" {
+		handle = oaiHandle(dc.Header.Identifier)
+	}
+	if handle == "" {
+		return r, rejection(r.Identifier, pipeline.ReasonNoIdentifier, "Record has no handle: %q", dc.Header.Identifier)
+	}
+	r.Identifier = "MIT:dspace:" + handle
+
+	if dc.Header.Status == "deleted" {
+		r.Deleted = true
+		return r, nil
+	}
+
+	// Title field
+	titles := skipEmpty(trimAll(d.Title))
+	if len(titles) == 0 {
+		return r, rejection(r.Identifier, pipeline.ReasonNoTitle, "Record %s has no title, check validity", r.Identifier)
+	}
+	r.Title = titles[0]
+	r.AlternateTitles = skipEmpty(append(titles[1:], trimAll(d.Alternative)...))
+
+	// Contributor field
+	r.Contributor = append(dcContributors(d.Creator, "author"), dcContributors(d.Contributor, "contributor")...)
+
+	// ContentType field
+	types := skipEmpty(trimAll(d.Type))
+	if len(types) > 0 {
+		r.ContentType = types[0]
+	}
+
+	// Doi field
+	r.Doi = dcDois(d.Identifier)
+
+	// Imprint field
+	r.Imprint = skipEmpty(trimAll(d.Publisher))
+
+	// Links field
+	r.Links = []record.Link{{
+		Kind: "Digital object",
+		URL:  dspaceHandleURL + handle,
+	}}
+
+	// Notes field
+	for _, rights := range skipEmpty(trimAll(d.Rights)) {
+		r.Notes = append(r.Notes, "Rights: "+rights)
+	}
+
+	// PublicationDate field
+	r.PublicationDate = dcDate(d.Issued, d.Date)
+
+	// Source field
+	r.Source = "DSpace@MIT"
+
+	// SourceLink field
+	r.SourceLink = dspaceHandleURL + handle
+
+	// Subject field
+	r.Subject = skipEmpty(trimAll(d.Subject))
+
+	// Summary field
+	r.Summary = skipEmpty(trimAll(append(d.Abstract, d.Description...)))
+
+	return r, nil
+}
+
+func trimAll(s []string) []string {
+	var r []string
+	for _, str := range s {
+		r = append(r, strings.TrimSpace(str))
+	}
+	return r
+}
+
+func dcContributors(names []string, kind string) []*record.Contributor {
+	var contribs []*record.Contributor
+	for _, name := range skipEmpty(trimAll(names)) {
+		contribs = append(contribs, &record.Contributor{Kind: kind, Value: name})
+	}
+	return contribs
+}
+
+// handlePattern matches a handle in any of the forms DSpace writes it,
+// such as http://hdl.handle.net/1721.1/12345 or hdl:1721.1/12345.
+var handlePattern = regexp.MustCompile(`^(?:https?://hdl\.handle\.net/|https?://dspace\.mit\.edu/handle/|hdl:)(\d[\d.]*/\d+)$`)
+
+// dcHandle returns the first handle among the identifiers.
+func dcHandle(identifiers []string) string {
+	for _, id := range identifiers {
+		if m := handlePattern.FindStringSubmatch(strings.TrimSpace(id)); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// oaiHandlePattern matches a DSpace OAI identifier such as
+// oai:dspace.mit.edu:1721.1/12345.
+var oaiHandlePattern = regexp.MustCompile(`^oai:[^:]+:(\d[\d.]*/\d+)$`)
+
+// oaiHandle returns the handle in an OAI identifier.
+func oaiHandle(id string) string {
+	if m := oaiHandlePattern.FindStringSubmatch(strings.TrimSpace(id)); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// doiPattern matches a DOI on its own, as a doi: URI or as a doi.org
+// URL.
+var doiPattern = regexp.MustCompile(`^(?:https?://(?:dx\.)?doi\.org/|doi:)?(10\.\d{4,}/\S+)$`)
+
+// dcDois returns the DOIs among the identifiers, without a prefix.
+func dcDois(identifiers []string) []string {
+	var dois []string
+	for _, id := range identifiers {
+		if m := doiPattern.FindStringSubmatch(strings.TrimSpace(id)); m != nil {
+			dois = append(dois, m[1])
+		}
+	}
+	return dois
+}
+
+// dcDate returns the date of issue. Simple Dublin Core from DSpace has
+// no dcterms:issued, but lists the dates the item was accessioned and
+// made available as timestamps alongside the date it was issued, so the
+// first date without a time is used.
+func dcDate(issued []string, dates []string) string {
+	if i := skipEmpty(trimAll(issued)); len(i) > 0 {
+		return i[0]
+	}
+	dates = skipEmpty(trimAll(dates))
+	for _, d := range dates {
+		if !strings.Contains(d, "T") {
+			return d
+		}
+	}
+	if len(dates) > 0 {
+		return dates[0]
+	}
+	return ""
+}
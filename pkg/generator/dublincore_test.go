@@ -0,0 +1,140 @@
+package generator
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mitlibraries/mario/pkg/pipeline"
+	"github.com/mitlibraries/mario/pkg/record"
+)
+
+func dspaceRecords(t *testing.T, skip int) ([]record.Record, []error) {
+	file, err := os.Open("../../fixtures/dspace_samples.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	errs := make(chan error, 10)
+	g := DublinCoreGenerator{File: file, Skip: skip}
+	var records []record.Record
+	for r := range g.Generate(context.Background(), errs) {
+		records = append(records, r)
+	}
+	close(errs)
+	var rejected []error
+	for err := range errs {
+		rejected = append(rejected, err)
+	}
+	return records, rejected
+}
+
+func TestDublinCoreSimple(t *testing.T) {
+	records, _ := dspaceRecords(t, 0)
+	if len(records) != 3 {
+		t.Fatal("Expected 3 records, got", len(records))
+	}
+	r := records[0]
+	expected := record.Record{
+		Identifier:      "MIT:dspace:1721.1/16641",
+		Source:          "DSpace@MIT",
+		SourceLink:      "https://dspace.mit.edu/handle/1721.1/16641",
+		Title:           "Predicting the behavior of granular flows",
+		Contributor:     []*record.Contributor{{Kind: "author", Value: "Rycroft, Chris H."}, {Kind: "contributor", Value: "Bazant, Martin Z."}},
+		Subject:         []string{"Mathematics.", "Granular materials"},
+		PublicationDate: "2007",
+		ContentType:     "Thesis",
+		Imprint:         []string{"Massachusetts Institute of Technology"},
+		Notes:           []string{"Rights: M.I.T. theses are protected by copyright."},
+		Summary:         []string{"Thesis (Ph. D.)--Massachusetts Institute of Technology, Dept. of Mathematics, 2007."},
+		Links:           []record.Link{{Kind: "Digital object", URL: "https://dspace.mit.edu/handle/1721.1/16641"}},
+	}
+	if !reflect.DeepEqual(r, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, r)
+	}
+}
+
+func TestDublinCoreQualified(t *testing.T) {
+	records, _ := dspaceRecords(t, 0)
+	r := records[1]
+	if r.Identifier != "MIT:dspace:1721.1/54321" {
+		t.Error("Expected MIT:dspace:1721.1/54321, got", r.Identifier)
+	}
+	if !reflect.DeepEqual(r.Doi, []string{"10.1000/xyz123", "10.5555/abc.456"}) {
+		t.Error("Unexpected DOIs", r.Doi)
+	}
+	if r.PublicationDate != "2010-03" {
+		t.Error("Expected 2010-03, got", r.PublicationDate)
+	}
+	if !reflect.DeepEqual(r.AlternateTitles, []string{"Open access"}) {
+		t.Error("Unexpected alternate titles", r.AlternateTitles)
+	}
+	if len(r.Contributor) != 2 || r.Contributor[1].Value != "Doe, John" {
+		t.Error("Unexpected contributors", r.Contributor)
+	}
+	if len(r.Notes) != 2 || r.ContentType != "Article" {
+		t.Error("Unexpected rights or type", r.Notes, r.ContentType)
+	}
+	if !reflect.DeepEqual(r.Summary, []string{"An article about open access."}) {
+		t.Error("Unexpected summary", r.Summary)
+	}
+}
+
+func TestDublinCoreDeleted(t *testing.T) {
+	records, _ := dspaceRecords(t, 0)
+	r := records[2]
+	if !r.Deleted || r.Identifier != "MIT:dspace:1721.1/99999" {
+		t.Error("Expected 1721.1/99999 to be deleted, got", r)
+	}
+}
+
+func TestDublinCoreRejectsMissingTitle(t *testing.T) {
+	_, rejected := dspaceRecords(t, 0)
+	if len(rejected) != 1 {
+		t.Fatal("Expected 1 rejection, got", rejected)
+	}
+	var re *pipeline.RecordError
+	if !errors.As(rejected[0], &re) {
+		t.Fatal("Expected a RecordError, got", rejected[0])
+	}
+	if re.Identifier != "MIT:dspace:1721.1/11111" || re.Reason != pipeline.ReasonNoTitle {
+		t.Error("Unexpected rejection", re.Identifier, re.Reason)
+	}
+	if !strings.HasPrefix(string(re.Raw), "<record>") {
+		t.Error("Expected the raw record element, got", string(re.Raw))
+	}
+}
+
+func TestDublinCoreSkip(t *testing.T) {
+	records, _ := dspaceRecords(t, 2)
+	if len(records) != 1 || !records[0].Deleted {
+		t.Error("Expected only the deleted record, got", records)
+	}
+}
+
+func TestDublinCoreIdentifiers(t *testing.T) {
+	var tests = []struct {
+		in     string
+		handle string
+		doi    string
+	}{
+		{"http://hdl.handle.net/1721.1/123", "1721.1/123", ""},
+		{"hdl:1721.1/123", "1721.1/123", ""},
+		{"https://dspace.mit.edu/handle/1721.1/123", "1721.1/123", ""},
+		{"http://dx.doi.org/10.1103/PhysRevLett.98.1", "", "10.1103/PhysRevLett.98.1"},
+		{"10.1103/PhysRevLett.98.1", "", "10.1103/PhysRevLett.98.1"},
+		{"Physical Review Letters 98, 1 (2007)", "", ""},
+	}
+	for _, tt := range tests {
+		if h := dcHandle([]string{tt.in}); h != tt.handle {
+			t.Errorf("%s: expected handle %q, got %q", tt.in, tt.handle, h)
+		}
+		dois := dcDois([]string{tt.in})
+		if (tt.doi == "" && dois != nil) || (tt.doi != "" && (len(dois) != 1 || dois[0] != tt.doi)) {
+			t.Errorf("%s: expected DOI %q, got %v", tt.in, tt.doi, dois)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package generator
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mitlibraries/mario/pkg/pipeline"
+	"github.com/mitlibraries/mario/pkg/record"
+)
+
+// send a Record on the out channel unless the context is done first. It
+// returns false if the Record was not sent.
+func send(ctx context.Context, out chan<- record.Record, r record.Record) bool {
+	select {
+	case out <- r:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// fail sends a fatal error and closes the out channel. It is used by
+// Generators which could not be set up.
+func fail(errs chan<- error, out chan record.Record, err error) {
+	errs <- err
+	close(out)
+}
+
+// rejection creates a RecordError for a record that was rejected for the
+// given reason.
+func rejection(id string, reason string, format string, a ...interface{}) *pipeline.RecordError {
+	return &pipeline.RecordError{
+		Identifier: id,
+		Reason:     reason,
+		Err:        fmt.Errorf(format, a...),
+	}
+}
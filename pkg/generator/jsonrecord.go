@@ -1,52 +1,72 @@
 package generator
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/mitlibraries/mario/pkg/record"
 	"io"
-	"log"
 )
 
 type jsonparser struct {
 	file io.Reader
+	skip int
 }
 
-//JSONGenerator parses JSON records.
+//JSONGenerator parses JSON records. The first Skip records in the file
+//are read but not generated.
 type JSONGenerator struct {
 	File io.Reader
+	Skip int
 }
 
-func (j *jsonparser) parse(out chan record.Record) {
+func (j *jsonparser) parse(ctx context.Context, out chan record.Record, errs chan<- error) {
+	defer close(out)
 	decoder := json.NewDecoder(j.file)
 
 	// read open bracket
 	_, err := decoder.Token()
 	if err != nil {
-		log.Fatal(err)
+		errs <- fmt.Errorf("Error reading JSON records: %w", err)
+		return
 	}
 
-	for decoder.More() {
+	for n := 0; ctx.Err() == nil && decoder.More(); n++ {
+		if n < j.skip {
+			var skipped json.RawMessage
+			err = decoder.Decode(&skipped)
+			if err != nil {
+				errs <- fmt.Errorf("Error reading JSON records: %w", err)
+				return
+			}
+			continue
+		}
 		var r record.Record
 		err = decoder.Decode(&r)
 		if err != nil {
-			log.Fatal(err)
+			errs <- fmt.Errorf("Error reading JSON records: %w", err)
+			return
+		}
+		if !send(ctx, out, r) {
+			return
 		}
-		out <- r
 	}
 
-	// read closing bracket
-	_, err = decoder.Token()
-	if err != nil {
-		log.Fatal(err)
+	if ctx.Err() == nil {
+		// read closing bracket
+		_, err = decoder.Token()
+		if err != nil {
+			errs <- fmt.Errorf("Error reading JSON records: %w", err)
+		}
 	}
-
-	close(out)
 }
 
-//Generate creates a channel of Records.
-func (j *JSONGenerator) Generate() <-chan record.Record {
+//Generate creates a channel of Records. The channel is closed early if
+//the context is cancelled. A malformed JSON stream is sent as a fatal
+//error.
+func (j *JSONGenerator) Generate(ctx context.Context, errs chan<- error) <-chan record.Record {
 	out := make(chan record.Record)
-	p := jsonparser{file: j.File}
-	go p.parse(out)
+	p := jsonparser{file: j.File, skip: j.Skip}
+	go p.parse(ctx, out, errs)
 	return out
 }
@@ -1,6 +1,7 @@
 package generator
 
 import (
+	"context"
 	"github.com/mitlibraries/mario/pkg/record"
 	"os"
 	"testing"
@@ -15,7 +16,7 @@ func TestJsonParser(t *testing.T) {
 	out := make(chan record.Record)
 
 	p := jsonparser{file: jsonfile}
-	go p.parse(out)
+	go p.parse(context.Background(), out, discard())
 
 	var chanLength int
 	for range out {
@@ -35,7 +36,7 @@ func TestJsonProcess(t *testing.T) {
 
 	var i int
 	p := JSONGenerator{File: jsonfile}
-	for range p.Generate() {
+	for range p.Generate(context.Background(), discard()) {
 		i++
 	}
 
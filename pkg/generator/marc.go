@@ -1,16 +1,17 @@
 package generator
 
 import (
+	"context"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"github.com/mitlibraries/mario/pkg/pipeline"
 	"github.com/mitlibraries/mario/pkg/record"
 	"io"
-	"log"
 	"strconv"
 	"strings"
 
-	"github.com/davecgh/go-spew/spew"
 	"github.com/markbates/pkger"
 	"github.com/mitlibraries/fml"
 )
@@ -35,71 +36,220 @@ func RetrieveRules(rulefile string) ([]*record.Rule, error) {
 	return rules, err
 }
 
+// marcRuleLabels are the rules marcToRecord expects to find in a rules
+// file.
+var marcRuleLabels = []string{
+	"alternate_titles", "call_numbers", "contents", "contributors", "dois",
+	"edition", "imprint", "in_bibliography", "isbns", "issns", "languages",
+	"lccn", "literary_form", "notes", "numbering", "oclc_number",
+	"physical_description", "place_of_publication", "publication_date",
+	"publication_frequency", "related_items", "related_place", "subjects",
+	"summary", "title",
+}
+
+// checkRules returns an error naming the first rule marcToRecord needs
+// that is missing from rules.
+func checkRules(rules []*record.Rule) error {
+	for _, label := range marcRuleLabels {
+		if getRules(rules, label) == nil {
+			return fmt.Errorf("MARC rules are missing the %s rule", label)
+		}
+	}
+	return nil
+}
+
+// marcReader reads MARC records one at a time. Raw returns a record as it
+// appeared in the input, so that it can be kept if the record is rejected.
+type marcReader interface {
+	Next() bool
+	Value() (fml.Record, error)
+	Err() error
+	Raw(fml.Record) []byte
+}
+
+// binaryReader reads binary MARC records.
+type binaryReader struct {
+	*fml.MarcIterator
+}
+
+// Raw returns the record with its record terminator.
+func (binaryReader) Raw(fmlRecord fml.Record) []byte {
+	return rawMarc(fmlRecord)
+}
+
+func newBinaryReader(file io.Reader) marcReader {
+	return binaryReader{fml.NewMarcIterator(file)}
+}
+
+// marcparser maps the records read from file to Records. Binary MARC is
+// read unless reader is set.
 type marcparser struct {
 	file          io.Reader
+	reader        func(io.Reader) marcReader
 	rules         []*record.Rule
 	languageCodes map[string]string
 	countryCodes  map[string]string
+	workers       int
+	ordered       bool
+	skip          int
 }
 
-//MarcGenerator parses binary MARC records.
-type MarcGenerator struct {
-	Marcfile  io.Reader
-	Rulesfile string
-}
-
-//Generate a channel of Records.
-func (m *MarcGenerator) Generate() <-chan record.Record {
-	rules, err := RetrieveRules(m.Rulesfile)
+// newMarcparser loads the rules and codelists needed to parse MARC.
+func newMarcparser(file io.Reader, rulesfile string) (*marcparser, error) {
+	rules, err := RetrieveRules(rulesfile)
 	if err != nil {
-		spew.Dump(err)
+		return nil, fmt.Errorf("Could not load MARC rules from %s: %w", rulesfile, err)
+	}
+	err = checkRules(rules)
+	if err != nil {
+		return nil, err
 	}
 
 	languageCodes, err := RetrieveCodelist("language", "/config/languages.xml")
 	if err != nil {
-		spew.Dump(err)
+		return nil, fmt.Errorf("Could not load language codes: %w", err)
 	}
 
 	countryCodes, err := RetrieveCodelist("country", "/config/countries.xml")
 	if err != nil {
-		spew.Dump(err)
+		return nil, fmt.Errorf("Could not load country codes: %w", err)
 	}
 
-	out := make(chan record.Record)
-	p := marcparser{file: m.Marcfile, rules: rules, languageCodes: languageCodes,
-		countryCodes: countryCodes}
-	go p.parse(out)
-	return out
+	return &marcparser{file: file, rules: rules, languageCodes: languageCodes,
+		countryCodes: countryCodes}, nil
 }
 
-func (m *marcparser) parse(out chan record.Record) {
-	mr := fml.NewMarcIterator(m.file)
-	var errorCount int
+//MarcGenerator parses MARC records. Format is the serialization of the
+//file: binary MARC if it is empty or "marc", or "marcxml", "mrk" or
+//"marcjson", see marcFormats. Mapping MARC to Records can be spread
+//across a number of Workers. Records will only be generated in the order
+//they appear in the file if Ordered is set or there is a single worker.
+//The first Skip records in the file are read but not mapped.
+type MarcGenerator struct {
+	Marcfile  io.Reader
+	Rulesfile string
+	Format    string
+	Workers   int
+	Ordered   bool
+	Skip      int
+}
 
-	for mr.Next() {
-		record, err := mr.Value()
+// marcFormats are the readers for each MARC serialization, by Format.
+var marcFormats = map[string]func(io.Reader) marcReader{
+	"":         newBinaryReader,
+	"marc":     newBinaryReader,
+	"marcxml":  newMarcxmlReader,
+	"mrk":      newMrkReader,
+	"marcjson": newMarcJSONReader,
+}
 
-		if err != nil {
-			log.Println("Error parsing MARC record:", record.ControlNum())
-			log.Println(err)
-			// os.Stderr.WriteString("--- Begin Problem MARC Record ---\n")
-			// os.Stderr.Write(record.Data)
-			// os.Stderr.WriteString("\n--- End Problem MARC Record ---\n")
-			errorCount++
-			continue
+//Generate a channel of Records. The channel is closed early if the
+//context is cancelled. An unknown Format, or failure to load the rules
+//or codelists or to read the file, is sent as a fatal error.
+func (m *MarcGenerator) Generate(ctx context.Context, errs chan<- error) <-chan record.Record {
+	reader, ok := marcFormats[m.Format]
+	if !ok {
+		out := make(chan record.Record)
+		go fail(errs, out, fmt.Errorf("Unknown MARC format %s", m.Format))
+		return out
+	}
+	p, err := newMarcparser(m.Marcfile, m.Rulesfile)
+	if err != nil {
+		out := make(chan record.Record)
+		go fail(errs, out, err)
+		return out
+	}
+	p.reader = reader
+	p.workers = m.Workers
+	p.ordered = m.Ordered
+	p.skip = m.Skip
+	return p.parse(ctx, errs)
+}
+
+// marcLeader reads the parts of a MARC leader which fml keeps.
+func marcLeader(leader string) (fml.Leader, error) {
+	if len(leader) < 24 {
+		return fml.Leader{}, errors.New("Invalid leader")
+	}
+	return fml.Leader{
+		Status:        leader[5],
+		Type:          leader[6],
+		BibLevel:      leader[7],
+		Control:       leader[8],
+		EncodingLevel: leader[17],
+		Form:          leader[18],
+		Multipart:     leader[19],
+	}, nil
+}
+
+// parse reads MARC records from the file and maps each one to a Record
+// using the parser's pool of workers.
+func (m *marcparser) parse(ctx context.Context, errs chan<- error) <-chan record.Record {
+	tasks := make(chan pipeline.Task)
+	go func() {
+		defer close(tasks)
+		reader := m.reader
+		if reader == nil {
+			reader = newBinaryReader
 		}
+		mr := reader(m.file)
 
-		r, err := marcToRecord(record, m.rules, m.languageCodes, m.countryCodes)
-		if err != nil {
-			errorCount++
-			log.Println(err)
-		} else {
-			out <- r
+		for n := 0; ctx.Err() == nil && mr.Next(); n++ {
+			if n < m.skip {
+				continue
+			}
+			fmlRecord, err := mr.Value()
+			tasks <- func() []record.Record {
+				return m.mapRecord(fmlRecord, err, mr.Raw, errs)
+			}
+		}
+
+		if err := mr.Err(); err != nil {
+			errs <- fmt.Errorf("Error reading MARC file: %w", err)
+		}
+	}()
+	return pipeline.RunTasks(tasks, m.workers, m.ordered)
+}
+
+// mapRecord maps a single MARC record, reporting a RecordError with the
+// record as returned by raw if it could not be parsed or mapped.
+func (m *marcparser) mapRecord(fmlRecord fml.Record, err error, raw func(fml.Record) []byte, errs chan<- error) []record.Record {
+	if err != nil {
+		errs <- &pipeline.RecordError{
+			Identifier: controlNum(fmlRecord),
+			Reason:     pipeline.ReasonParse,
+			Raw:        raw(fmlRecord),
+			Err:        fmt.Errorf("Error parsing MARC record %s: %w", controlNum(fmlRecord), err),
+		}
+		return nil
+	}
+
+	r, err := marcToRecord(fmlRecord, m.rules, m.languageCodes, m.countryCodes)
+	if err != nil {
+		var re *pipeline.RecordError
+		if !errors.As(err, &re) {
+			re = &pipeline.RecordError{Identifier: r.Identifier, Reason: pipeline.ReasonParse, Err: err}
 		}
+		re.Raw = raw(fmlRecord)
+		errs <- re
+		return nil
 	}
+	return []record.Record{r}
+}
 
-	log.Printf("Error records: %s", strconv.Itoa(errorCount))
-	close(out)
+// rawMarc returns the record as it appeared in the binary MARC file,
+// including the record terminator.
+func rawMarc(fmlRecord fml.Record) []byte {
+	return append([]byte(fmlRecord.Data), 0x1d)
+}
+
+// controlNum returns the record's control number, or an empty string if
+// the record has no 001 field.
+func controlNum(r fml.Record) string {
+	if len(r.ControlField("001")) == 0 {
+		return ""
+	}
+	return r.ControlNum()
 }
 
 func validRecordStatus(record fml.Record) bool {
@@ -114,15 +264,19 @@ func marcToRecord(fmlRecord fml.Record, rules []*record.Rule, languageCodes map[
 	err = nil
 	r = record.Record{}
 
-	r.Identifier = fmlRecord.ControlNum()
+	r.Identifier = controlNum(fmlRecord)
+	if r.Identifier == "" {
+		err = rejection(r.Identifier, pipeline.ReasonNoIdentifier, "Record has no control number")
+		return r, err
+	}
 
 	if fmlRecord.Leader.Status == 'd' {
-		err = fmt.Errorf("Record %s has been deleted or suppressed but we don't handle that yet", r.Identifier)
+		r.Deleted = true
 		return r, err
 	}
 
 	if !validRecordStatus(fmlRecord) {
-		err = fmt.Errorf("Record %s has illegal status: %s", r.Identifier, string(fmlRecord.Leader.Status))
+		err = rejection(r.Identifier, pipeline.ReasonStatus, "Record %s has illegal status: %s", r.Identifier, string(fmlRecord.Leader.Status))
 		return r, err
 	}
 
@@ -141,7 +295,7 @@ func marcToRecord(fmlRecord fml.Record, rules []*record.Rule, languageCodes map[
 	if title != nil {
 		r.Title = title[0]
 	} else {
-		err = fmt.Errorf("Record %s has no title, check validity", r.Identifier)
+		err = rejection(r.Identifier, pipeline.ReasonNoTitle, "Record %s has no title, check validity", r.Identifier)
 		return r, err
 	}
 
@@ -328,7 +482,7 @@ func getRules(rules []*record.Rule, label string) *record.Rule {
 			return v
 		}
 	}
-	return nil // TODO: this will lead to a panic and end the world. While this is ultimately an appropriate response to failing to find rules we expect to find, it would be better to handle that explictly and log something that explains it before terminating cleanly.
+	return nil // checkRules guarantees the rules used by marcToRecord are present.
 }
 
 func literaryForm(x []string) string {
@@ -383,8 +537,9 @@ func contentType(x byte) string {
 func RetrieveCodelist(codeType string, filePath string) (map[string]string, error) {
 	file, err := pkger.Open(filePath)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+	defer file.Close()
 	// Language struct
 	type CodeMap struct {
 		Name string `xml:"name"`
@@ -395,20 +550,26 @@ func RetrieveCodelist(codeType string, filePath string) (map[string]string, erro
 	codes := make(map[string]string)
 
 	for {
-		t, _ := decoder.Token()
-		if t == nil {
+		t, err := decoder.Token()
+		if err == io.EOF {
 			break
 		}
+		if err != nil {
+			return nil, err
+		}
 		switch se := t.(type) {
 		case xml.StartElement:
 			if se.Name.Local == codeType {
 				var c CodeMap
-				decoder.DecodeElement(&c, &se)
+				err = decoder.DecodeElement(&c, &se)
+				if err != nil {
+					return nil, err
+				}
 				codes[c.Code] = c.Name
 			}
 		}
 	}
-	return codes, err
+	return codes, nil
 }
 
 // TranslateCodes takes an array of MARC language/country codes and returns the language/country names.
@@ -1,11 +1,16 @@
 package generator
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
 	"os"
 	"testing"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/mitlibraries/fml"
+	"github.com/mitlibraries/mario/pkg/pipeline"
 	"github.com/mitlibraries/mario/pkg/record"
 )
 
@@ -220,10 +225,8 @@ func TestMarcParser(t *testing.T) {
 		t.Error(err)
 	}
 
-	out := make(chan record.Record)
-
 	p := marcparser{file: marcfile, rules: rules}
-	go p.parse(out)
+	out := p.parse(context.Background(), discard())
 
 	var chanLength int
 	for range out {
@@ -241,7 +244,7 @@ func TestMarcProcess(t *testing.T) {
 		t.Error(err)
 	}
 	p := MarcGenerator{Marcfile: marcfile, Rulesfile: "/config/marc_rules.json"}
-	out := p.Generate()
+	out := p.Generate(context.Background(), discard())
 	var i int
 	for range out {
 		i++
@@ -310,3 +313,179 @@ func TestOclcs(t *testing.T) {
 		t.Error("Expected 1, got", len(item.OclcNumber))
 	}
 }
+
+func TestMarcProcessWorkers(t *testing.T) {
+	marcfile, err := os.Open("../../fixtures/test.mrc")
+	if err != nil {
+		t.Error(err)
+	}
+	serial, err := os.Open("../../fixtures/test.mrc")
+	if err != nil {
+		t.Error(err)
+	}
+	p := MarcGenerator{Marcfile: marcfile, Rulesfile: "/config/marc_rules.json", Workers: 4, Ordered: true}
+	s := MarcGenerator{Marcfile: serial, Rulesfile: "/config/marc_rules.json"}
+	expected := s.Generate(context.Background(), discard())
+	var i int
+	for r := range p.Generate(context.Background(), discard()) {
+		e := <-expected
+		if r.Identifier != e.Identifier {
+			t.Errorf("Record %d: expected %s, got %s", i, e.Identifier, r.Identifier)
+		}
+		i++
+	}
+	if i != 85 {
+		t.Error("Expected match, got", i)
+	}
+}
+
+func TestMarcProcessSkip(t *testing.T) {
+	marcfile, err := os.Open("../../fixtures/test.mrc")
+	if err != nil {
+		t.Error(err)
+	}
+	all, err := os.Open("../../fixtures/test.mrc")
+	if err != nil {
+		t.Error(err)
+	}
+	p := MarcGenerator{Marcfile: marcfile, Rulesfile: "/config/marc_rules.json", Skip: 80}
+	s := MarcGenerator{Marcfile: all, Rulesfile: "/config/marc_rules.json"}
+	var expected []string
+	for r := range s.Generate(context.Background(), discard()) {
+		expected = append(expected, r.Identifier)
+	}
+	var got []string
+	for r := range p.Generate(context.Background(), discard()) {
+		got = append(got, r.Identifier)
+	}
+	if len(got) != 5 {
+		t.Fatal("Expected 5, got", len(got))
+	}
+	for i, id := range got {
+		if id != expected[80+i] {
+			t.Errorf("Record %d: expected %s, got %s", i, expected[80+i], id)
+		}
+	}
+}
+
+func TestMarcProcessCancelled(t *testing.T) {
+	marcfile, err := os.Open("../../fixtures/test.mrc")
+	if err != nil {
+		t.Error(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p := MarcGenerator{Marcfile: marcfile, Rulesfile: "/config/marc_rules.json"}
+	var i int
+	for range p.Generate(ctx, discard()) {
+		i++
+	}
+	if i != 0 {
+		t.Error("Expected 0, got", i)
+	}
+}
+
+func TestMarcProcessMissingRules(t *testing.T) {
+	marcfile, err := os.Open("../../fixtures/test.mrc")
+	if err != nil {
+		t.Error(err)
+	}
+	errs := make(chan error, 1)
+	p := MarcGenerator{Marcfile: marcfile, Rulesfile: "/config/missing_rules.json"}
+	var i int
+	for range p.Generate(context.Background(), errs) {
+		i++
+	}
+	if i != 0 {
+		t.Error("Expected 0, got", i)
+	}
+	if err := <-errs; err == nil {
+		t.Error("Expected an error, got", err)
+	}
+}
+
+func TestCheckRules(t *testing.T) {
+	rules, err := RetrieveRules("/config/marc_rules.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkRules(rules); err != nil {
+		t.Error("Expected nil, got", err)
+	}
+	if err := checkRules(rules[1:]); err == nil {
+		t.Error("Expected an error, got", err)
+	}
+}
+
+// discard returns an error channel which is drained in the background.
+func discard() chan<- error {
+	errs := make(chan error)
+	go func() {
+		for range errs {
+		}
+	}()
+	return errs
+}
+
+func TestMarcRejectsKeepRawRecord(t *testing.T) {
+	data, err := ioutil.ReadFile("../../fixtures/record1.mrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Give the record an illegal leader status
+	data[5] = 'x'
+
+	errs := make(chan error, 1)
+	p := MarcGenerator{Marcfile: bytes.NewReader(data), Rulesfile: "/config/marc_rules.json"}
+	for range p.Generate(context.Background(), errs) {
+		t.Error("Expected no records")
+	}
+
+	var re *pipeline.RecordError
+	if !errors.As(<-errs, &re) {
+		t.Fatal("Expected a RecordError")
+	}
+	if re.Reason != pipeline.ReasonStatus {
+		t.Error("Expected match, got", re.Reason)
+	}
+	if re.Identifier != "92005291" {
+		t.Error("Expected match, got", re.Identifier)
+	}
+	if !bytes.Equal(re.Raw, data) {
+		t.Error("Expected raw record to match input")
+	}
+}
+
+func TestMarcDeletedRecord(t *testing.T) {
+	data, err := ioutil.ReadFile("../../fixtures/record1.mrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[5] = 'd'
+
+	p := MarcGenerator{Marcfile: bytes.NewReader(data), Rulesfile: "/config/marc_rules.json"}
+	var records []record.Record
+	for r := range p.Generate(context.Background(), discard()) {
+		records = append(records, r)
+	}
+	if len(records) != 1 {
+		t.Fatal("Expected 1 record, got", len(records))
+	}
+	if !records[0].Deleted {
+		t.Error("Expected record to be deleted")
+	}
+	if records[0].Identifier != "92005291" {
+		t.Error("Expected match, got", records[0].Identifier)
+	}
+}
+
+func TestMarcUnknownFormat(t *testing.T) {
+	errs := make(chan error, 1)
+	g := MarcGenerator{Marcfile: bytes.NewReader(nil), Format: "marc8"}
+	for range g.Generate(context.Background(), errs) {
+		t.Error("Expected no records")
+	}
+	if err := <-errs; err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
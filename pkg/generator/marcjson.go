@@ -0,0 +1,150 @@
+package generator
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/mitlibraries/fml"
+)
+
+// marcJSONRecord is a MARC-in-JSON record. Each field is an object with
+// the tag as its only key.
+type marcJSONRecord struct {
+	Leader string                       `json:"leader"`
+	Fields []map[string]json.RawMessage `json:"fields"`
+}
+
+// marcJSONField is the value of a MARC-in-JSON data field. Each subfield
+// is an object with the code as its only key.
+type marcJSONField struct {
+	Ind1      string              `json:"ind1"`
+	Ind2      string              `json:"ind2"`
+	Subfields []map[string]string `json:"subfields"`
+}
+
+// fmlRecord converts the record to an fml.Record, as if it had been read
+// from binary MARC. Data is set to raw.
+func (x marcJSONRecord) fmlRecord(raw []byte) (fml.Record, error) {
+	r := fml.Record{Data: string(raw)}
+	for _, field := range x.Fields {
+		for tag, value := range field {
+			if len(value) > 0 && value[0] == '"' {
+				var cf string
+				if err := json.Unmarshal(value, &cf); err != nil {
+					return r, fmt.Errorf("Invalid control field %s: %w", tag, err)
+				}
+				r.Fields = append(r.Fields, fml.ControlField{Tag: tag, Value: cf})
+				continue
+			}
+			var df marcJSONField
+			if err := json.Unmarshal(value, &df); err != nil {
+				return r, fmt.Errorf("Invalid data field %s: %w", tag, err)
+			}
+			d := fml.DataField{
+				Tag:        tag,
+				Indicator1: indicator(df.Ind1),
+				Indicator2: indicator(df.Ind2),
+			}
+			for _, sf := range df.Subfields {
+				for code, v := range sf {
+					d.SubFields = append(d.SubFields, fml.SubField{Code: code, Value: v})
+				}
+			}
+			r.Fields = append(r.Fields, d)
+		}
+	}
+	var err error
+	r.Leader, err = marcLeader(x.Leader)
+	return r, err
+}
+
+// marcJSONReader streams MARC-in-JSON records. The file can hold a single
+// record, an array of records or a stream of records, such as one per
+// line.
+type marcJSONReader struct {
+	file    *bufio.Reader
+	decoder *json.Decoder
+	array   bool
+	record  fml.Record
+	invalid error
+	err     error
+}
+
+func newMarcJSONReader(file io.Reader) marcReader {
+	return &marcJSONReader{file: bufio.NewReader(file)}
+}
+
+// start works out whether the records are in an array, and if so reads
+// the opening bracket.
+func (m *marcJSONReader) start() error {
+	for {
+		b, err := m.file.Peek(1)
+		if err != nil {
+			return err
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			m.file.ReadByte()
+			continue
+		case '[':
+			m.array = true
+		}
+		m.decoder = json.NewDecoder(m.file)
+		if m.array {
+			_, err = m.decoder.Token()
+		}
+		return err
+	}
+}
+
+// Next decodes the next record. Records which are not valid MARC are
+// returned with an error by Value, but malformed JSON stops the reader.
+func (m *marcJSONReader) Next() bool {
+	if m.decoder == nil {
+		err := m.start()
+		if err != nil {
+			if err != io.EOF {
+				m.err = err
+			}
+			return false
+		}
+	}
+	if m.array && !m.decoder.More() {
+		return false
+	}
+	var raw json.RawMessage
+	err := m.decoder.Decode(&raw)
+	if err == io.EOF {
+		return false
+	}
+	if err != nil {
+		m.err = err
+		return false
+	}
+	var x marcJSONRecord
+	err = json.Unmarshal(raw, &x)
+	if err != nil {
+		m.record, m.invalid = fml.Record{Data: string(raw)}, err
+		return true
+	}
+	m.record, m.invalid = x.fmlRecord(raw)
+	return true
+}
+
+// Value returns the record read by Next.
+func (m *marcJSONReader) Value() (fml.Record, error) {
+	return m.record, m.invalid
+}
+
+// Err returns the error which stopped the reader, if any.
+func (m *marcJSONReader) Err() error {
+	return m.err
+}
+
+// Raw returns the record as it appeared in the file, on a line of its
+// own.
+func (m *marcJSONReader) Raw(fmlRecord fml.Record) []byte {
+	return append([]byte(fmlRecord.Data), '\n')
+}
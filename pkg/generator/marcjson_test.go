@@ -0,0 +1,54 @@
+package generator
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mitlibraries/mario/pkg/record"
+)
+
+func TestMarcJSONMatchesBinary(t *testing.T) {
+	marcfile, err := os.Open("../../fixtures/test.mrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonfile, err := os.Open("../../fixtures/test.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := MarcGenerator{Marcfile: marcfile, Rulesfile: "/config/marc_rules.json"}
+	j := MarcGenerator{Format: "marcjson", Marcfile: jsonfile, Rulesfile: "/config/marc_rules.json"}
+	var expected []record.Record
+	for r := range m.Generate(context.Background(), discard()) {
+		expected = append(expected, r)
+	}
+	var got []record.Record
+	for r := range j.Generate(context.Background(), discard()) {
+		got = append(got, r)
+	}
+	if len(got) != 85 || len(got) != len(expected) {
+		t.Fatalf("Expected %d records, got %d", len(expected), len(got))
+	}
+	for i := range got {
+		if !reflect.DeepEqual(got[i], expected[i]) {
+			t.Errorf("Record %d: expected %+v, got %+v", i, expected[i], got[i])
+		}
+	}
+}
+
+func TestMarcJSONStream(t *testing.T) {
+	data := `{"leader":"00000nam a2200000 a 4500","fields":[{"001":"1"},{"245":{"ind1":"1","ind2":"0","subfields":[{"a":"One"}]}}]}
+{"leader":"00000nam a2200000 a 4500","fields":[{"001":"2"},{"245":{"ind1":"1","ind2":"0","subfields":[{"a":"Two"}]}}]}
+`
+	j := MarcGenerator{Format: "marcjson", Marcfile: strings.NewReader(data), Rulesfile: "/config/marc_rules.json"}
+	var titles []string
+	for r := range j.Generate(context.Background(), discard()) {
+		titles = append(titles, r.Title)
+	}
+	if strings.Join(titles, ",") != "One,Two" {
+		t.Error("Expected One,Two, got", titles)
+	}
+}
@@ -0,0 +1,125 @@
+package generator
+
+import (
+	"encoding/xml"
+	"io"
+
+	"github.com/mitlibraries/fml"
+)
+
+// marcxmlNamespace is the MARC 21 slim schema namespace.
+const marcxmlNamespace = "http://www.loc.gov/MARC21/slim"
+
+// marcxmlRecord is a MARCXML record element.
+type marcxmlRecord struct {
+	Leader        string `xml:"leader"`
+	ControlFields []struct {
+		Tag   string `xml:"tag,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"controlfield"`
+	DataFields []struct {
+		Tag       string `xml:"tag,attr"`
+		Ind1      string `xml:"ind1,attr"`
+		Ind2      string `xml:"ind2,attr"`
+		SubFields []struct {
+			Code  string `xml:"code,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"subfield"`
+	} `xml:"datafield"`
+}
+
+// indicator returns a MARCXML indicator as fml has it, with a missing
+// indicator treated as blank.
+func indicator(ind string) string {
+	if ind == "" {
+		return " "
+	}
+	return ind
+}
+
+// fmlRecord converts the record to an fml.Record, as if it had been read
+// from binary MARC. Data is set to raw.
+func (x marcxmlRecord) fmlRecord(raw []byte) (fml.Record, error) {
+	r := fml.Record{Data: string(raw)}
+	for _, cf := range x.ControlFields {
+		r.Fields = append(r.Fields, fml.ControlField{Tag: cf.Tag, Value: cf.Value})
+	}
+	for _, df := range x.DataFields {
+		d := fml.DataField{
+			Tag:        df.Tag,
+			Indicator1: indicator(df.Ind1),
+			Indicator2: indicator(df.Ind2),
+		}
+		for _, sf := range df.SubFields {
+			d.SubFields = append(d.SubFields, fml.SubField{Code: sf.Code, Value: sf.Value})
+		}
+		r.Fields = append(r.Fields, d)
+	}
+	leader, err := marcLeader(x.Leader)
+	r.Leader = leader
+	return r, err
+}
+
+// marcxmlReader streams record elements from MARCXML, either a collection
+// of records or a single record. Elements named
+// record in other namespaces, such as an OAI-PMH envelope, are looked
+// inside rather than read as MARC.
+type marcxmlReader struct {
+	raw     *recorder
+	decoder *xml.Decoder
+	record  fml.Record
+	invalid error
+	err     error
+}
+
+func newMarcxmlReader(file io.Reader) marcReader {
+	raw := newRecorder(file)
+	return &marcxmlReader{raw: raw, decoder: xml.NewDecoder(raw)}
+}
+
+// Next reads up to the end of the next record element. Records which are
+// not valid MARC are returned with an error by Value, but malformed XML
+// stops the reader.
+func (m *marcxmlReader) Next() bool {
+	for {
+		m.raw.mark(m.decoder.InputOffset())
+		t, err := m.decoder.Token()
+		if err == io.EOF {
+			return false
+		}
+		if err != nil {
+			m.err = err
+			return false
+		}
+		se, ok := t.(xml.StartElement)
+		if !ok || se.Name.Local != "record" {
+			continue
+		}
+		if se.Name.Space != marcxmlNamespace && se.Name.Space != "" {
+			continue
+		}
+		var x marcxmlRecord
+		err = m.decoder.DecodeElement(&x, &se)
+		if err != nil {
+			m.err = err
+			return false
+		}
+		m.record, m.invalid = x.fmlRecord(m.raw.since(m.decoder.InputOffset()))
+		return true
+	}
+}
+
+// Value returns the record read by Next.
+func (m *marcxmlReader) Value() (fml.Record, error) {
+	return m.record, m.invalid
+}
+
+// Err returns the error which stopped the reader, if any.
+func (m *marcxmlReader) Err() error {
+	return m.err
+}
+
+// Raw returns the record element as it appeared in the file.
+func (m *marcxmlReader) Raw(fmlRecord fml.Record) []byte {
+	return append([]byte(fmlRecord.Data), '\n')
+}
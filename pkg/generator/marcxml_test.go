@@ -0,0 +1,82 @@
+package generator
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mitlibraries/mario/pkg/pipeline"
+	"github.com/mitlibraries/mario/pkg/record"
+)
+
+func TestMarcXMLMatchesBinary(t *testing.T) {
+	marcfile, err := os.Open("../../fixtures/test.mrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	xmlfile, err := os.Open("../../fixtures/test.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := MarcGenerator{Marcfile: marcfile, Rulesfile: "/config/marc_rules.json"}
+	x := MarcGenerator{Format: "marcxml", Marcfile: xmlfile, Rulesfile: "/config/marc_rules.json"}
+	var expected []record.Record
+	for r := range m.Generate(context.Background(), discard()) {
+		expected = append(expected, r)
+	}
+	var got []record.Record
+	for r := range x.Generate(context.Background(), discard()) {
+		got = append(got, r)
+	}
+	if len(got) != 85 || len(got) != len(expected) {
+		t.Fatalf("Expected %d records, got %d", len(expected), len(got))
+	}
+	for i := range got {
+		if !reflect.DeepEqual(got[i], expected[i]) {
+			t.Errorf("Record %d: expected %+v, got %+v", i, expected[i], got[i])
+		}
+	}
+}
+
+func TestMarcXMLSkip(t *testing.T) {
+	xmlfile, err := os.Open("../../fixtures/test.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := MarcGenerator{Format: "marcxml", Marcfile: xmlfile, Rulesfile: "/config/marc_rules.json", Skip: 80}
+	var i int
+	for range x.Generate(context.Background(), discard()) {
+		i++
+	}
+	if i != 5 {
+		t.Error("Expected 5, got", i)
+	}
+}
+
+func TestMarcXMLRejectsKeepRawRecord(t *testing.T) {
+	data := `<collection xmlns="http://www.loc.gov/MARC21/slim">
+  <record>
+    <leader>00000nam</leader>
+    <controlfield tag="001">12345</controlfield>
+  </record>
+</collection>`
+	errs := make(chan error, 1)
+	x := MarcGenerator{Format: "marcxml", Marcfile: strings.NewReader(data), Rulesfile: "/config/marc_rules.json"}
+	for range x.Generate(context.Background(), errs) {
+		t.Error("Expected no records")
+	}
+	var re *pipeline.RecordError
+	if !errors.As(<-errs, &re) {
+		t.Fatal("Expected a RecordError")
+	}
+	if re.Identifier != "12345" || re.Reason != pipeline.ReasonParse {
+		t.Error("Unexpected rejection", re.Identifier, re.Reason)
+	}
+	if !strings.HasPrefix(strings.TrimSpace(string(re.Raw)), "<record>") ||
+		!strings.HasSuffix(strings.TrimSpace(string(re.Raw)), "</record>") {
+		t.Error("Expected the raw record element, got", string(re.Raw))
+	}
+}
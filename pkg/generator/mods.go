@@ -0,0 +1,545 @@
+package generator
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mitlibraries/mario/pkg/pipeline"
+	"github.com/mitlibraries/mario/pkg/record"
+)
+
+// oaiNamespace is the OAI-PMH namespace.
+const oaiNamespace = "http://www.openarchives.org/OAI/2.0/"
+
+// ModsGenerator parses MODS, either a modsCollection, a single mods
+// element or OAI-PMH records with MODS metadata. Contributors, related
+// items and links are mapped to the same kinds as the MARC mapping uses.
+// Records deleted at the source are generated with Deleted set. The first
+// Skip records in the file are read but not mapped.
+type ModsGenerator struct {
+	File io.Reader
+	Skip int
+}
+
+// Generate a channel of Records. The channel is closed early if the
+// context is cancelled. Failure to read the XML stream is sent as a fatal
+// error.
+func (m *ModsGenerator) Generate(ctx context.Context, errs chan<- error) <-chan record.Record {
+	out := make(chan record.Record)
+	go m.parse(ctx, out, errs)
+	return out
+}
+
+// parse streams the xml file and maps each record found. A mods element
+// inside an OAI-PMH record is read along with the record's header.
+func (m *ModsGenerator) parse(ctx context.Context, out chan record.Record, errs chan<- error) {
+	defer close(out)
+	raw := newRecorder(m.File)
+	decoder := xml.NewDecoder(raw)
+	var n int
+
+	for ctx.Err() == nil {
+		raw.mark(decoder.InputOffset())
+		t, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			errs <- fmt.Errorf("Error reading MODS file: %w", err)
+			return
+		}
+		se, ok := t.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		oai := se.Name.Local == "record" && se.Name.Space == oaiNamespace
+		if !oai && se.Name.Local != "mods" {
+			continue
+		}
+		n++
+		if n <= m.Skip {
+			if err := decoder.Skip(); err != nil {
+				errs <- fmt.Errorf("Error reading MODS file: %w", err)
+				return
+			}
+			continue
+		}
+		var mr modsRecord
+		if oai {
+			err = decoder.DecodeElement(&mr, &se)
+		} else {
+			err = decoder.DecodeElement(&mr.Metadata.Mods, &se)
+		}
+		if err != nil {
+			errs <- fmt.Errorf("Error reading MODS file: %w", err)
+			return
+		}
+		r, err := mr.record()
+		if err != nil {
+			var re *pipeline.RecordError
+			if !errors.As(err, &re) {
+				re = &pipeline.RecordError{Identifier: r.Identifier, Reason: pipeline.ReasonParse, Err: err}
+			}
+			re.Raw = append(raw.since(decoder.InputOffset()), '\n')
+			errs <- re
+			continue
+		}
+		if !send(ctx, out, r) {
+			return
+		}
+	}
+}
+
+// modsRecord is an OAI-PMH record with MODS metadata. Only Metadata.Mods
+// is set for MODS outside an OAI-PMH response.
+type modsRecord struct {
+	Header struct {
+		Identifier string `xml:"identifier"`
+		Status     string `xml:"status,attr"`
+	} `xml:"header"`
+	Metadata struct {
+		Mods mods `xml:"mods"`
+	} `xml:"metadata"`
+}
+
+// mods is a mods element.
+type mods struct {
+	TitleInfo           []modsTitleInfo  `xml:"titleInfo"`
+	Name                []modsName       `xml:"name"`
+	TypeOfResource      []string         `xml:"typeOfResource"`
+	OriginInfo          []modsOriginInfo `xml:"originInfo"`
+	Subject             []modsSubject    `xml:"subject"`
+	Identifier          []modsTyped      `xml:"identifier"`
+	PhysicalDescription []struct {
+		Form   []string `xml:"form"`
+		Extent []string `xml:"extent"`
+	} `xml:"physicalDescription"`
+	Abstract    []string          `xml:"abstract"`
+	Note        []string          `xml:"note"`
+	Location    []modsLocation    `xml:"location"`
+	RelatedItem []modsRelatedItem `xml:"relatedItem"`
+	RecordInfo  struct {
+		ContentSource    string `xml:"recordContentSource"`
+		RecordIdentifier string `xml:"recordIdentifier"`
+	} `xml:"recordInfo"`
+}
+
+type modsTitleInfo struct {
+	Type       string   `xml:"type,attr"`
+	NonSort    string   `xml:"nonSort"`
+	Title      string   `xml:"title"`
+	SubTitle   string   `xml:"subTitle"`
+	PartNumber []string `xml:"partNumber"`
+	PartName   []string `xml:"partName"`
+}
+
+type modsName struct {
+	Usage    string      `xml:"usage,attr"`
+	NamePart []modsTyped `xml:"namePart"`
+	Role     []struct {
+		RoleTerm []modsTyped `xml:"roleTerm"`
+	} `xml:"role"`
+}
+
+type modsOriginInfo struct {
+	Place []struct {
+		PlaceTerm []modsTyped `xml:"placeTerm"`
+	} `xml:"place"`
+	Publisher   []string   `xml:"publisher"`
+	DateIssued  []modsDate `xml:"dateIssued"`
+	DateCreated []modsDate `xml:"dateCreated"`
+	Edition     string     `xml:"edition"`
+	Frequency   []string   `xml:"frequency"`
+}
+
+type modsDate struct {
+	Encoding string `xml:"encoding,attr"`
+	KeyDate  string `xml:"keyDate,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type modsSubject struct {
+	Terms []struct {
+		XMLName xml.Name
+		Value   string      `xml:",chardata"`
+		Parts   []modsTyped `xml:"namePart"`
+		Title   string      `xml:"title"`
+	} `xml:",any"`
+}
+
+type modsLocation struct {
+	URL []struct {
+		DisplayLabel string `xml:"displayLabel,attr"`
+		Note         string `xml:"note,attr"`
+		Usage        string `xml:"usage,attr"`
+		Access       string `xml:"access,attr"`
+		Value        string `xml:",chardata"`
+	} `xml:"url"`
+}
+
+type modsRelatedItem struct {
+	Type      string          `xml:"type,attr"`
+	TitleInfo []modsTitleInfo `xml:"titleInfo"`
+}
+
+// modsTyped is an element with a type attribute, such as an identifier
+// or a namePart.
+type modsTyped struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// record maps the element to a Record.
+func (mr modsRecord) record() (record.Record, error) {
+	r := record.Record{}
+	m := mr.Metadata.Mods
+
+	// Identifier field
+	id := strings.TrimSpace(m.RecordInfo.RecordIdentifier)
+	if id == "" {
+		id = strings.TrimSpace(mr.Header.Identifier)
+	}
+	if id == "" {
+		return r, rejection(r.Identifier, pipeline.ReasonNoIdentifier, "Record has no recordIdentifier")
+	}
+	r.Identifier = "MIT:mods:" + id
+
+	if mr.Header.Status == "deleted" {
+		r.Deleted = true
+		return r, nil
+	}
+
+	// Title and AlternateTitles fields
+	for _, ti := range m.TitleInfo {
+		title := ti.String()
+		if title == "" {
+			continue
+		}
+		if r.Title == "" && ti.Type == "" {
+			r.Title = title
+		} else {
+			r.AlternateTitles = append(r.AlternateTitles, title)
+		}
+	}
+	if r.Title == "" {
+		return r, rejection(r.Identifier, pipeline.ReasonNoTitle, "Record %s has no title, check validity", r.Identifier)
+	}
+
+	// Contributor field
+	r.Contributor = modsContributors(m.Name)
+
+	// ContentType field
+	if len(m.TypeOfResource) > 0 {
+		r.ContentType = modsContentType(m.TypeOfResource[0])
+	}
+
+	// Identifier fields
+	for _, i := range m.Identifier {
+		v := strings.TrimSpace(i.Value)
+		switch i.Type {
+		case "isbn":
+			r.Isbn = append(r.Isbn, v)
+		case "issn":
+			r.Issn = append(r.Issn, v)
+		case "doi":
+			r.Doi = append(r.Doi, v)
+		case "oclc":
+			r.OclcNumber = append(r.OclcNumber, v)
+		case "lccn":
+			if r.Lccn == "" {
+				r.Lccn = v
+			}
+		}
+	}
+
+	// Origin fields
+	for _, o := range m.OriginInfo {
+		if imprint := o.imprint(); imprint != "" {
+			r.Imprint = append(r.Imprint, imprint)
+		}
+		if r.PublicationDate == "" {
+			r.PublicationDate = o.date()
+		}
+		if r.Edition == "" {
+			r.Edition = strings.TrimSpace(o.Edition)
+		}
+		r.PublicationFrequency = append(r.PublicationFrequency, skipEmpty(trimAll(o.Frequency))...)
+	}
+
+	// PhysicalDescription and Format fields
+	var extents []string
+	for _, pd := range m.PhysicalDescription {
+		extents = append(extents, skipEmpty(trimAll(pd.Extent))...)
+		r.Format = append(r.Format, skipEmpty(trimAll(pd.Form))...)
+	}
+	r.PhysicalDescription = strings.Join(extents, "; ")
+
+	// Subject field
+	for _, s := range m.Subject {
+		if subject := s.String(); subject != "" {
+			r.Subject = append(r.Subject, subject)
+		}
+	}
+
+	// Links and SourceLink fields
+	r.Links, r.SourceLink = modsLinks(m.Location)
+
+	// RelatedItems field
+	r.RelatedItems = modsRelatedItems(m.RelatedItem)
+
+	// Notes field
+	r.Notes = skipEmpty(trimAll(m.Note))
+
+	// Summary field
+	r.Summary = skipEmpty(trimAll(m.Abstract))
+
+	// Source field
+	r.Source = strings.TrimSpace(m.RecordInfo.ContentSource)
+	if r.Source == "" {
+		r.Source = "MIT Libraries"
+	}
+
+	return r, nil
+}
+
+// String joins the parts of a title with spaces, as the MARC mapping
+// joins the subfields of a title field.
+func (ti modsTitleInfo) String() string {
+	parts := []string{ti.NonSort, ti.Title, ti.SubTitle}
+	parts = append(parts, ti.PartNumber...)
+	parts = append(parts, ti.PartName...)
+	return strings.Join(skipEmpty(trimAll(parts)), " ")
+}
+
+// modsAuthorRoles are the role terms which make a name an author rather
+// than a contributor.
+var modsAuthorRoles = map[string]bool{
+	"aut":     true,
+	"cre":     true,
+	"author":  true,
+	"creator": true,
+}
+
+// modsContributors maps names to contributors. As in the MARC mapping,
+// the primary name is an author and the rest are contributors, and the
+// role terms are part of the value. Names with no primary usage are
+// authors if their role says so.
+func modsContributors(names []modsName) []*record.Contributor {
+	var contribs []*record.Contributor
+	for _, n := range names {
+		parts := []string{n.String()}
+		kind := "contributor"
+		if n.Usage == "primary" {
+			kind = "author"
+		}
+		for _, role := range n.Role {
+			for _, term := range role.RoleTerm {
+				v := strings.TrimSpace(term.Value)
+				if modsAuthorRoles[strings.ToLower(strings.Trim(v, "."))] {
+					kind = "author"
+				}
+				if term.Type != "code" {
+					parts = append(parts, v)
+				}
+			}
+		}
+		value := strings.Join(skipEmpty(trimAll(parts)), " ")
+		if value != "" {
+			contribs = append(contribs, &record.Contributor{Kind: kind, Value: value})
+		}
+	}
+	return contribs
+}
+
+// String joins the parts of a name with spaces, as the MARC mapping
+// joins the subfields of a name field. A family name is put before the
+// given name as it is in a heading.
+func (n modsName) String() string {
+	var family, given, parts []string
+	for _, p := range n.NamePart {
+		switch p.Type {
+		case "family":
+			family = append(family, p.Value)
+		case "given":
+			given = append(given, p.Value)
+		default:
+			parts = append(parts, p.Value)
+		}
+	}
+	name := strings.Join(skipEmpty(trimAll(family)), " ")
+	if g := strings.Join(skipEmpty(trimAll(given)), " "); g != "" {
+		if name != "" {
+			name += ", "
+		}
+		name += g
+	}
+	return strings.Join(skipEmpty(trimAll(append([]string{name}, parts...))), " ")
+}
+
+// modsContentTypes are the content types the MARC mapping uses for each
+// typeOfResource.
+var modsContentTypes = map[string]string{
+	"cartographic":               "Cartographic material",
+	"notated music":              "Musical score",
+	"sound recording":            "Sound recording",
+	"sound recording-musical":    "Sound recording",
+	"sound recording-nonmusical": "Sound recording",
+	"still image":                "Still image",
+	"moving image":               "Moving image",
+	"three dimensional object":   "Object",
+	"software, multimedia":       "Computer file",
+	"mixed material":             "Mixed materials",
+}
+
+func modsContentType(typeOfResource string) string {
+	if t, ok := modsContentTypes[strings.TrimSpace(typeOfResource)]; ok {
+		return t
+	}
+	return "Text"
+}
+
+// imprint joins the place, publisher and date of issue, in the order
+// they appear in a MARC 260 field.
+func (o modsOriginInfo) imprint() string {
+	var places, dates []string
+	for _, p := range o.Place {
+		for _, term := range p.PlaceTerm {
+			if term.Type != "code" {
+				places = append(places, term.Value)
+			}
+		}
+	}
+	for _, d := range o.DateIssued {
+		if d.Encoding == "" {
+			dates = append(dates, d.Value)
+		}
+	}
+	var imprint []string
+	if p := strings.Join(skipEmpty(trimAll(places)), " "); p != "" {
+		imprint = append(imprint, p)
+	}
+	if p := strings.Join(skipEmpty(trimAll(o.Publisher)), " "); p != "" {
+		if len(imprint) > 0 {
+			p = ": " + p
+		}
+		imprint = append(imprint, p)
+	}
+	if d := strings.Join(skipEmpty(trimAll(dates)), " "); d != "" {
+		imprint = append(imprint, d)
+	}
+	return strings.Join(imprint, " ")
+}
+
+// date returns the key date of issue or creation, or the first one.
+func (o modsOriginInfo) date() string {
+	var dates []modsDate
+	dates = append(dates, o.DateIssued...)
+	dates = append(dates, o.DateCreated...)
+	for _, d := range dates {
+		if d.KeyDate == "yes" && strings.TrimSpace(d.Value) != "" {
+			return strings.TrimSpace(d.Value)
+		}
+	}
+	for _, d := range dates {
+		if strings.TrimSpace(d.Value) != "" {
+			return strings.TrimSpace(d.Value)
+		}
+	}
+	return ""
+}
+
+// String joins the terms of a subject with spaces, as the MARC mapping
+// joins the subfields of a subject field.
+func (s modsSubject) String() string {
+	var terms []string
+	for _, t := range s.Terms {
+		switch t.XMLName.Local {
+		case "name":
+			for _, p := range t.Parts {
+				terms = append(terms, p.Value)
+			}
+		case "titleInfo":
+			terms = append(terms, t.Title)
+		case "topic", "geographic", "temporal", "genre", "occupation":
+			terms = append(terms, t.Value)
+		}
+	}
+	return strings.Join(skipEmpty(trimAll(terms)), " ")
+}
+
+// modsLinks maps urls to links the same way the MARC mapping maps 856
+// fields, with the display label as the kind and the note as the
+// restrictions. The source link is the url for the object in context or
+// the primary display, or else the first url.
+func modsLinks(locations []modsLocation) ([]record.Link, string) {
+	var links []record.Link
+	var primary, first string
+	for _, l := range locations {
+		for _, u := range l.URL {
+			link := record.Link{
+				Kind:         strings.TrimSpace(u.DisplayLabel),
+				URL:          strings.TrimSpace(u.Value),
+				Restrictions: strings.TrimSpace(u.Note),
+			}
+			if link.URL == "" {
+				continue
+			}
+			if link.Kind == "" {
+				link.Kind = "unknown"
+			}
+			links = append(links, link)
+			if first == "" {
+				first = link.URL
+			}
+			if primary == "" && (u.Usage == "primary display" || u.Usage == "primary" || u.Access == "object in context") {
+				primary = link.URL
+			}
+		}
+	}
+	if primary == "" {
+		primary = first
+	}
+	return links, primary
+}
+
+// modsRelatedKinds are the related item kinds the MARC mapping uses for
+// each relatedItem type. Other types are related.
+var modsRelatedKinds = map[string]string{
+	"original":   "original language version",
+	"preceding":  "previous title",
+	"succeeding": "subsequent title",
+	"series":     "in series",
+}
+
+// modsRelatedItems groups the titles of related items by kind, as the
+// MARC mapping does.
+func modsRelatedItems(items []modsRelatedItem) []*record.RelatedItem {
+	var related []*record.RelatedItem
+	byKind := map[string]*record.RelatedItem{}
+	for _, item := range items {
+		var titles []string
+		for _, ti := range item.TitleInfo {
+			titles = append(titles, ti.String())
+		}
+		title := strings.Join(skipEmpty(titles), " ")
+		if title == "" {
+			continue
+		}
+		kind, ok := modsRelatedKinds[item.Type]
+		if !ok {
+			kind = "related"
+		}
+		ri, ok := byKind[kind]
+		if !ok {
+			ri = &record.RelatedItem{Kind: kind}
+			byKind[kind] = ri
+			related = append(related, ri)
+		}
+		ri.Value = append(ri.Value, title)
+	}
+	return related
+}
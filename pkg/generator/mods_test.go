@@ -0,0 +1,140 @@
+package generator
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mitlibraries/mario/pkg/pipeline"
+	"github.com/mitlibraries/mario/pkg/record"
+)
+
+func modsRecords(t *testing.T, skip int) ([]record.Record, []error) {
+	file, err := os.Open("../../fixtures/mods_samples.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	errs := make(chan error, 10)
+	g := ModsGenerator{File: file, Skip: skip}
+	var records []record.Record
+	for r := range g.Generate(context.Background(), errs) {
+		records = append(records, r)
+	}
+	close(errs)
+	var rejected []error
+	for err := range errs {
+		rejected = append(rejected, err)
+	}
+	return records, rejected
+}
+
+func TestModsRecordParsing(t *testing.T) {
+	records, _ := modsRecords(t, 0)
+	if len(records) != 2 {
+		t.Fatal("Expected 2 records, got", len(records))
+	}
+	expected := record.Record{
+		Identifier:      "MIT:mods:dc-0001",
+		Source:          "MIT Libraries Digital Collections",
+		SourceLink:      "https://example.mit.edu/object/123",
+		Title:           "The Boston waterfront photographs, 1950-1970",
+		AlternateTitles: []string{"Waterfront photographs"},
+		Contributor: []*record.Contributor{
+			{Kind: "author", Value: "Halberstadt, Hans, 1921-1995 photographer."},
+			{Kind: "contributor", Value: "Massachusetts Institute of Technology. Department of Urban Studies and Planning."},
+		},
+		Subject:             []string{"Waterfronts Massachusetts Boston Pictorial works.", "Lynch, Kevin, 1918-1984"},
+		Isbn:                []string{"0262120046"},
+		Doi:                 []string{"10.1000/boston.1"},
+		OclcNumber:          []string{"12345678"},
+		Lccn:                "71123456",
+		PublicationDate:     "1971",
+		ContentType:         "Still image",
+		Edition:             "2nd ed.",
+		Imprint:             []string{"Boston, Mass. : Boston Redevelopment Authority, 1971."},
+		PhysicalDescription: "120 photographs :; b&w ; 20 x 25 cm.",
+		Notes:               []string{"Gift of the photographer."},
+		Summary:             []string{"Photographs of the Boston waterfront before redevelopment."},
+		Format:              []string{"print"},
+		RelatedItems: []*record.RelatedItem{
+			{Kind: "in series", Value: []string{"Urban studies photographs no. 4"}},
+			{Kind: "previous title", Value: []string{"Boston harbor views"}},
+			{Kind: "related", Value: []string{"Boston waterfront (online)", "Kevin Lynch papers"}},
+		},
+		Links: []record.Link{
+			{Kind: "Finding aid", URL: "https://example.mit.edu/findingaid/123"},
+			{Kind: "unknown", URL: "https://example.mit.edu/object/123", Restrictions: "MIT access only"},
+		},
+	}
+	if !reflect.DeepEqual(records[0], expected) {
+		t.Errorf("Expected %+v, got %+v", expected, records[0])
+	}
+}
+
+func TestModsDefaults(t *testing.T) {
+	records, _ := modsRecords(t, 0)
+	r := records[1]
+	if r.Source != "MIT Libraries" {
+		t.Error("Expected MIT Libraries, got", r.Source)
+	}
+	if r.SourceLink != "https://example.mit.edu/object/456" {
+		t.Error("Expected the only url as the source link, got", r.SourceLink)
+	}
+	if len(r.Contributor) != 1 || r.Contributor[0].Kind != "author" || r.Contributor[0].Value != "Smith, Jane" {
+		t.Error("Expected author Smith, Jane, got", r.Contributor)
+	}
+	if r.PublicationDate != "2001" || r.ContentType != "Text" {
+		t.Error("Unexpected date or content type", r.PublicationDate, r.ContentType)
+	}
+}
+
+func TestModsRejectsMissingTitle(t *testing.T) {
+	_, rejected := modsRecords(t, 0)
+	if len(rejected) != 1 {
+		t.Fatal("Expected 1 rejection, got", rejected)
+	}
+	var re *pipeline.RecordError
+	if !errors.As(rejected[0], &re) {
+		t.Fatal("Expected a RecordError, got", rejected[0])
+	}
+	if re.Identifier != "MIT:mods:dc-0003" || re.Reason != pipeline.ReasonNoTitle {
+		t.Error("Unexpected rejection", re.Identifier, re.Reason)
+	}
+	if !strings.HasPrefix(string(re.Raw), "<mods") || !strings.HasSuffix(strings.TrimSpace(string(re.Raw)), "</mods>") {
+		t.Error("Expected the raw mods element, got", string(re.Raw))
+	}
+}
+
+func TestModsSkip(t *testing.T) {
+	records, _ := modsRecords(t, 1)
+	if len(records) != 1 || records[0].Identifier != "MIT:mods:dc-0002" {
+		t.Error("Expected only dc-0002, got", records)
+	}
+}
+
+func TestModsOAI(t *testing.T) {
+	data := `<OAI-PMH xmlns="http://www.openarchives.org/OAI/2.0/"><ListRecords>
+<record><header><identifier>oai:example:1</identifier></header><metadata>
+<mods xmlns="http://www.loc.gov/mods/v3"><titleInfo><title>Harvested</title></titleInfo></mods>
+</metadata></record>
+<record><header status="deleted"><identifier>oai:example:2</identifier></header></record>
+</ListRecords></OAI-PMH>`
+	g := ModsGenerator{File: strings.NewReader(data)}
+	var records []record.Record
+	for r := range g.Generate(context.Background(), discard()) {
+		records = append(records, r)
+	}
+	if len(records) != 2 {
+		t.Fatal("Expected 2 records, got", len(records))
+	}
+	if records[0].Identifier != "MIT:mods:oai:example:1" || records[0].Title != "Harvested" {
+		t.Error("Unexpected record", records[0])
+	}
+	if !records[1].Deleted || records[1].Identifier != "MIT:mods:oai:example:2" {
+		t.Error("Expected oai:example:2 to be deleted, got", records[1])
+	}
+}
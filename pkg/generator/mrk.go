@@ -0,0 +1,124 @@
+package generator
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mitlibraries/fml"
+)
+
+// mrkMnemonics replaces the mnemonics .mrk uses for characters which
+// have a meaning in the format.
+var mrkMnemonics = strings.NewReplacer(
+	"{dollar}", "$",
+	"{bsol}", "\\",
+	"{lcub}", "{",
+	"{rcub}", "}",
+)
+
+// mrkBlanks replaces the backslashes .mrk uses for blanks in the leader,
+// control fields and indicators.
+func mrkBlanks(s string) string {
+	return strings.Replace(s, "\\", " ", -1)
+}
+
+// mrkRecord converts the lines of a .mrk record to an fml.Record, as if
+// it had been read from binary MARC. Each line is a field such as
+// =245  10$aArithmetic /$cCarl Sandburg.
+func mrkRecord(lines []string) (fml.Record, error) {
+	r := fml.Record{Data: strings.Join(lines, "\n")}
+	var leader string
+	for _, line := range lines {
+		if len(line) < 4 || line[0] != '=' {
+			return r, fmt.Errorf("Invalid line %q", line)
+		}
+		tag := line[1:4]
+		data := strings.TrimPrefix(line[4:], "  ")
+		switch {
+		case tag == "LDR":
+			leader = mrkMnemonics.Replace(mrkBlanks(data))
+		case strings.HasPrefix(tag, "00"):
+			r.Fields = append(r.Fields, fml.ControlField{Tag: tag, Value: mrkMnemonics.Replace(mrkBlanks(data))})
+		default:
+			if len(data) < 2 {
+				return r, fmt.Errorf("Invalid indicators in %s", tag)
+			}
+			d := fml.DataField{
+				Tag:        tag,
+				Indicator1: mrkBlanks(data[:1]),
+				Indicator2: mrkBlanks(data[1:2]),
+			}
+			for _, sf := range strings.Split(data[2:], "$")[1:] {
+				if len(sf) == 0 {
+					return r, fmt.Errorf("Empty subfield in %s", tag)
+				}
+				d.SubFields = append(d.SubFields, fml.SubField{Code: sf[:1], Value: mrkMnemonics.Replace(sf[1:])})
+			}
+			r.Fields = append(r.Fields, d)
+		}
+	}
+	if leader == "" {
+		return r, errors.New("Missing leader")
+	}
+	var err error
+	r.Leader, err = marcLeader(leader)
+	return r, err
+}
+
+// mrkReader reads records in the mnemonic .mrk format written by
+// MarcEdit, which are separated by blank lines.
+type mrkReader struct {
+	scanner *bufio.Scanner
+	record  fml.Record
+	invalid error
+}
+
+func newMrkReader(file io.Reader) marcReader {
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	return &mrkReader{scanner: scanner}
+}
+
+// Next reads the lines of the next record.
+func (m *mrkReader) Next() bool {
+	var lines []string
+	for m.scanner.Scan() {
+		line := strings.TrimRight(m.scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			if len(lines) > 0 {
+				break
+			}
+			continue
+		}
+		if line[0] != '=' && len(lines) > 0 {
+			// A field continued on the next line
+			lines[len(lines)-1] += line
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return false
+	}
+	m.record, m.invalid = mrkRecord(lines)
+	return true
+}
+
+// Value returns the record read by Next.
+func (m *mrkReader) Value() (fml.Record, error) {
+	return m.record, m.invalid
+}
+
+// Err returns the error which stopped the reader, if any.
+func (m *mrkReader) Err() error {
+	return m.scanner.Err()
+}
+
+// Raw returns the record's lines as they appeared in the file, followed
+// by the blank line which ends a record.
+func (m *mrkReader) Raw(fmlRecord fml.Record) []byte {
+	return []byte(fmlRecord.Data + "\n\n")
+}
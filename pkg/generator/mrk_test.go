@@ -0,0 +1,72 @@
+package generator
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mitlibraries/mario/pkg/record"
+)
+
+func TestMrkMatchesBinary(t *testing.T) {
+	marcfile, err := os.Open("../../fixtures/test.mrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mrkfile, err := os.Open("../../fixtures/test.mrk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := MarcGenerator{Marcfile: marcfile, Rulesfile: "/config/marc_rules.json"}
+	k := MarcGenerator{Format: "mrk", Marcfile: mrkfile, Rulesfile: "/config/marc_rules.json"}
+	var expected []record.Record
+	for r := range m.Generate(context.Background(), discard()) {
+		expected = append(expected, r)
+	}
+	var got []record.Record
+	for r := range k.Generate(context.Background(), discard()) {
+		got = append(got, r)
+	}
+	if len(got) != 85 || len(got) != len(expected) {
+		t.Fatalf("Expected %d records, got %d", len(expected), len(got))
+	}
+	for i := range got {
+		if !reflect.DeepEqual(got[i], expected[i]) {
+			t.Errorf("Record %d: expected %+v, got %+v", i, expected[i], got[i])
+		}
+	}
+}
+
+func TestMrkRecord(t *testing.T) {
+	lines := []string{
+		`=LDR  00000nam\a2200000\a\4500`,
+		`=001  12345`,
+		`=008  200101s2020\\\\mau\\\\\\\\\\\000\0\eng\d`,
+		`=245  10$aPrices in {dollar} /$cA. Person.`,
+	}
+	r, err := mrkRecord(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Leader.Status != 'n' || r.Leader.Type != 'a' {
+		t.Error("Unexpected leader", r.Leader)
+	}
+	if v := r.ControlField("008")[0].Value; !strings.HasPrefix(v, "200101s2020    mau") {
+		t.Error("Expected blanks in 008, got", v)
+	}
+	df := r.DataField("245")[0]
+	if df.Indicator1 != "1" || df.Indicator2 != "0" {
+		t.Error("Unexpected indicators", df.Indicator1, df.Indicator2)
+	}
+	if df.SubFields[0].Value != "Prices in $ /" || df.SubFields[1].Code != "c" {
+		t.Error("Unexpected subfields", df.SubFields)
+	}
+}
+
+func TestMrkMissingLeader(t *testing.T) {
+	if _, err := mrkRecord([]string{"=001  12345"}); err == nil {
+		t.Error("Expected an error for a record without a leader")
+	}
+}
@@ -0,0 +1,211 @@
+package generator
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/mitlibraries/mario/pkg/pipeline"
+	"github.com/mitlibraries/mario/pkg/record"
+)
+
+// Harvest is an OAI-PMH ListRecords request. From and Until are
+// datestamps such as 2020-04-01, and can be left empty along with Set.
+type Harvest struct {
+	BaseURL        string
+	MetadataPrefix string
+	Set            string
+	From           string
+	Until          string
+}
+
+// params returns the query for the first page of the harvest.
+func (h Harvest) params() url.Values {
+	v := url.Values{}
+	v.Set("verb", "ListRecords")
+	v.Set("metadataPrefix", h.MetadataPrefix)
+	if h.Set != "" {
+		v.Set("set", h.Set)
+	}
+	if h.From != "" {
+		v.Set("from", h.From)
+	}
+	if h.Until != "" {
+		v.Set("until", h.Until)
+	}
+	return v
+}
+
+// OAIGenerator harvests records from an OAI-PMH repository, following
+// resumption tokens until the list is complete. Each page of the
+// response is handed to the Generator returned by Mapping, which reads
+// the records out of the OAI-PMH envelope. ArchivesGenerator and a
+// MarcGenerator for MARCXML can both be used this way.
+//
+// Requests which fail with a network error or a 429 or 5xx status are
+// retried up to Retries times, waiting Backoff before the first retry and
+// twice as long before each one after, or as long as the server asks.
+// The first Skip records harvested are mapped but not generated.
+type OAIGenerator struct {
+	Harvest
+	Mapping func(io.Reader) pipeline.Generator
+	Client  *http.Client
+	Retries int
+	Backoff time.Duration
+	Skip    int
+}
+
+// Generate a channel of Records. The channel is closed early if the
+// context is cancelled. A request which still fails after being retried,
+// or an OAI-PMH error other than noRecordsMatch, is sent as a fatal
+// error.
+func (g *OAIGenerator) Generate(ctx context.Context, errs chan<- error) <-chan record.Record {
+	out := make(chan record.Record)
+	go g.harvest(ctx, out, errs)
+	return out
+}
+
+// harvest requests each page in turn and maps its records.
+func (g *OAIGenerator) harvest(ctx context.Context, out chan record.Record, errs chan<- error) {
+	defer close(out)
+	params := g.params()
+	var n int
+	for ctx.Err() == nil {
+		page, err := g.fetch(ctx, params)
+		if err != nil {
+			if ctx.Err() == nil {
+				errs <- fmt.Errorf("Error harvesting %s: %w", g.BaseURL, err)
+			}
+			return
+		}
+		status, err := readOAIStatus(page)
+		if err != nil {
+			errs <- fmt.Errorf("Error harvesting %s: %w", g.BaseURL, err)
+			return
+		}
+		if !status.empty {
+			for r := range g.Mapping(bytes.NewReader(page)).Generate(ctx, errs) {
+				n++
+				if n <= g.Skip {
+					continue
+				}
+				if !send(ctx, out, r) {
+					return
+				}
+			}
+		}
+		if status.token == "" {
+			return
+		}
+		params = url.Values{}
+		params.Set("verb", "ListRecords")
+		params.Set("resumptionToken", status.token)
+	}
+}
+
+// oaiStatus is what harvest needs to know about a page besides its
+// records.
+type oaiStatus struct {
+	token string
+	empty bool
+}
+
+// readOAIStatus finds the resumption token in a ListRecords response. An
+// OAI-PMH error is returned as an error, except for noRecordsMatch which
+// means the harvest is empty.
+func readOAIStatus(page []byte) (oaiStatus, error) {
+	var res struct {
+		Error *struct {
+			Code    string `xml:"code,attr"`
+			Message string `xml:",chardata"`
+		} `xml:"error"`
+		Token string `xml:"ListRecords>resumptionToken"`
+	}
+	err := xml.Unmarshal(page, &res)
+	if err != nil {
+		return oaiStatus{}, err
+	}
+	if res.Error != nil {
+		if res.Error.Code == "noRecordsMatch" {
+			return oaiStatus{empty: true}, nil
+		}
+		return oaiStatus{}, fmt.Errorf("OAI-PMH error %s: %s", res.Error.Code, res.Error.Message)
+	}
+	return oaiStatus{token: res.Token}, nil
+}
+
+// retryable reports whether a request which got the status should be
+// tried again.
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// fetch requests a page of the harvest, retrying transient failures.
+func (g *OAIGenerator) fetch(ctx context.Context, params url.Values) ([]byte, error) {
+	client := g.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	wait := g.Backoff
+	for attempt := 0; ; attempt++ {
+		page, f := g.get(ctx, client, params)
+		if f == nil {
+			return page, nil
+		}
+		if !f.retry || attempt >= g.Retries || ctx.Err() != nil {
+			return nil, f.err
+		}
+		if f.wait > wait {
+			wait = f.wait
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		wait *= 2
+	}
+}
+
+// requestFailure describes a failed request. If it can be retried, wait
+// is how long the server asked for before trying again.
+type requestFailure struct {
+	err   error
+	retry bool
+	wait  time.Duration
+}
+
+// get makes a single request.
+func (g *OAIGenerator) get(ctx context.Context, client *http.Client, params url.Values) ([]byte, *requestFailure) {
+	req, err := http.NewRequest("GET", g.BaseURL+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, &requestFailure{err: err}
+	}
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, &requestFailure{err: err, retry: true}
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		f := &requestFailure{
+			err:   fmt.Errorf("Unexpected status %s", res.Status),
+			retry: retryable(res.StatusCode),
+		}
+		if secs, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+			f.wait = time.Duration(secs) * time.Second
+		}
+		return nil, f
+	}
+	page, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, &requestFailure{err: err, retry: true}
+	}
+	return page, nil
+}
@@ -0,0 +1,143 @@
+package generator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mitlibraries/mario/pkg/pipeline"
+)
+
+// oaiPage returns a ListRecords response with a MARCXML record for each
+// identifier.
+func oaiPage(token string, ids ...string) string {
+	var b strings.Builder
+	b.WriteString(`<OAI-PMH xmlns="http://www.openarchives.org/OAI/2.0/"><ListRecords>`)
+	for _, id := range ids {
+		fmt.Fprintf(&b, `<record><header><identifier>oai:test:%s</identifier></header><metadata>
+<marc:record xmlns:marc="http://www.loc.gov/MARC21/slim">
+<marc:leader>00000nam a2200000 a 4500</marc:leader>
+<marc:controlfield tag="001">%s</marc:controlfield>
+<marc:datafield tag="245" ind1="1" ind2="0"><marc:subfield code="a">Title %s</marc:subfield></marc:datafield>
+</marc:record></metadata></record>`, id, id, id)
+	}
+	fmt.Fprintf(&b, `<resumptionToken>%s</resumptionToken></ListRecords></OAI-PMH>`, token)
+	return b.String()
+}
+
+func marcxmlMapping(r io.Reader) pipeline.Generator {
+	return &MarcGenerator{Format: "marcxml", Marcfile: r, Rulesfile: "/config/marc_rules.json"}
+}
+
+func TestOAIHarvest(t *testing.T) {
+	var failed bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case q.Get("verb") != "ListRecords":
+			http.Error(w, "bad verb", http.StatusBadRequest)
+		case q.Get("resumptionToken") == "":
+			if q.Get("metadataPrefix") != "marc21" || q.Get("set") != "theses" || q.Get("from") != "2020-01-01" {
+				http.Error(w, "bad arguments", http.StatusBadRequest)
+				return
+			}
+			io.WriteString(w, oaiPage("page2", "1", "2"))
+		case !failed:
+			failed = true
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		default:
+			io.WriteString(w, oaiPage("", "3"))
+		}
+	}))
+	defer srv.Close()
+
+	g := OAIGenerator{
+		Harvest: Harvest{BaseURL: srv.URL, MetadataPrefix: "marc21", Set: "theses", From: "2020-01-01"},
+		Mapping: marcxmlMapping,
+		Retries: 2,
+	}
+	errs := make(chan error, 1)
+	var ids []string
+	for r := range g.Generate(context.Background(), errs) {
+		ids = append(ids, r.Identifier)
+	}
+	select {
+	case err := <-errs:
+		t.Fatal(err)
+	default:
+	}
+	if strings.Join(ids, ",") != "1,2,3" {
+		t.Error("Expected 1,2,3, got", ids)
+	}
+	if !failed {
+		t.Error("Expected a failed request to be retried")
+	}
+}
+
+func TestOAIHarvestSkip(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("resumptionToken") == "" {
+			io.WriteString(w, oaiPage("page2", "1", "2"))
+		} else {
+			io.WriteString(w, oaiPage("", "3"))
+		}
+	}))
+	defer srv.Close()
+	g := OAIGenerator{Harvest: Harvest{BaseURL: srv.URL}, Mapping: marcxmlMapping, Skip: 2}
+	var ids []string
+	for r := range g.Generate(context.Background(), discard()) {
+		ids = append(ids, r.Identifier)
+	}
+	if strings.Join(ids, ",") != "3" {
+		t.Error("Expected 3, got", ids)
+	}
+}
+
+func TestOAIHarvestNoRecords(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `<OAI-PMH xmlns="http://www.openarchives.org/OAI/2.0/"><error code="noRecordsMatch">No records</error></OAI-PMH>`)
+	}))
+	defer srv.Close()
+	g := OAIGenerator{Harvest: Harvest{BaseURL: srv.URL}, Mapping: marcxmlMapping}
+	errs := make(chan error, 1)
+	for range g.Generate(context.Background(), errs) {
+		t.Error("Expected no records")
+	}
+	select {
+	case err := <-errs:
+		t.Error("Expected no error, got", err)
+	default:
+	}
+}
+
+func TestOAIHarvestErrors(t *testing.T) {
+	var tests = []http.HandlerFunc{
+		func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, `<OAI-PMH xmlns="http://www.openarchives.org/OAI/2.0/"><error code="badArgument">Bad</error></OAI-PMH>`)
+		},
+		func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "gone", http.StatusNotFound)
+		},
+		func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		},
+	}
+	for i, handler := range tests {
+		srv := httptest.NewServer(handler)
+		g := OAIGenerator{Harvest: Harvest{BaseURL: srv.URL}, Mapping: marcxmlMapping, Retries: 1}
+		errs := make(chan error, 1)
+		for range g.Generate(context.Background(), errs) {
+			t.Error("Expected no records")
+		}
+		select {
+		case <-errs:
+		default:
+			t.Errorf("Test %d: expected an error", i)
+		}
+		srv.Close()
+	}
+}
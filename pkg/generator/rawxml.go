@@ -0,0 +1,62 @@
+package generator
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// recorder wraps a reader and keeps a copy of what has been read so that
+// the raw XML of a rejected record can be recovered. xml.Decoder reads an
+// io.ByteReader directly rather than buffering ahead, so offsets reported
+// by Decoder.InputOffset line up with the bytes kept here.
+type recorder struct {
+	r    *bufio.Reader
+	buf  bytes.Buffer
+	base int64 // offset of the first byte in buf
+	n    int64 // offset of the next byte to be read
+}
+
+func newRecorder(r io.Reader) *recorder {
+	return &recorder{r: bufio.NewReader(r)}
+}
+
+func (r *recorder) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.buf.WriteByte(b)
+		r.n++
+	}
+	return b, err
+}
+
+func (r *recorder) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.buf.Write(p[:n])
+	r.n += int64(n)
+	return n, err
+}
+
+// mark discards everything recorded before the offset.
+func (r *recorder) mark(offset int64) {
+	if offset > r.n {
+		offset = r.n
+	}
+	if offset > r.base {
+		r.buf.Next(int(offset - r.base))
+		r.base = offset
+	}
+}
+
+// since returns a copy of the bytes between the last mark and the offset.
+func (r *recorder) since(offset int64) []byte {
+	b := r.buf.Bytes()
+	end := offset - r.base
+	if end < 0 {
+		end = 0
+	}
+	if end > int64(len(b)) {
+		end = int64(len(b))
+	}
+	return append([]byte(nil), b[:end]...)
+}
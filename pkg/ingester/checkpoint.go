@@ -0,0 +1,215 @@
+package ingester
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mitlibraries/mario/pkg/client"
+	"github.com/mitlibraries/mario/pkg/consumer"
+)
+
+// Checkpoint records how far an ingest into an index has got, so that an
+// interrupted run can be resumed. Filename is the path the ingest was
+// given, and File the file it had reached.
+//
+// Offset is the number of records at the start of File which can be
+// skipped on resume. It only counts records which have been flushed to
+// the index, and stops short of the first document Elasticsearch would
+// not accept, so it errs on the side of reading some records again.
+// Records read again are indexed under the same identifier, but may be
+// counted a second time.
+type Checkpoint struct {
+	Filename string    `json:"filename"`
+	Index    string    `json:"index"`
+	File     string    `json:"file"`
+	Offset   int       `json:"offset"`
+	Ingested int       `json:"ingested"`
+	Rejected int       `json:"rejected"`
+	Complete bool      `json:"complete"`
+	Updated  time.Time `json:"updated"`
+}
+
+// LoadCheckpoint reads a Checkpoint from a local file or S3 object.
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	stream, err := NewStream(path)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	var cp Checkpoint
+	err = json.NewDecoder(stream).Decode(&cp)
+	if err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// Save the Checkpoint to a local file or S3 object. Local files are
+// replaced atomically so that a crash never leaves a partial checkpoint.
+func (cp *Checkpoint) Save(path string) error {
+	b, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	parts, err := url.Parse(path)
+	if err != nil {
+		return err
+	}
+	if parts.Scheme != "s3" {
+		tmp := path + ".tmp"
+		err = ioutil.WriteFile(tmp, b, 0644)
+		if err != nil {
+			return err
+		}
+		return os.Rename(tmp, path)
+	}
+	sink, err := NewSink(path)
+	if err != nil {
+		return err
+	}
+	_, err = sink.Write(b)
+	if cerr := sink.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// checkpointer saves checkpoints for an ingest into Elasticsearch. Counts
+// are added to those of the checkpoint the run started from.
+//
+// The consumer must track the records it adds, so that a document which
+// fails in bulk can be traced back to its file and position. The
+// checkpoint is then held at the first such document, and never marked
+// complete, so that resuming reads it again.
+type checkpointer struct {
+	path     string
+	start    Checkpoint
+	consumer *consumer.ESConsumer
+	client   client.Indexer
+	rejects  *rejectTally
+	mu       sync.Mutex
+	files    []position
+	seen     int
+	missing  []string
+	held     *position
+}
+
+// position is a place in the input. For the start of a file, added is
+// the number of records the consumer had added when the file was reached.
+type position struct {
+	n      int
+	file   string
+	offset int
+	added  int
+}
+
+// next records that the ingest has moved on to file, skipping the first
+// offset records. Every record from the previous file must have been
+// handed to the consumer.
+func (c *checkpointer) next(file string, offset int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.files = append(c.files, position{
+		n:      len(c.files),
+		file:   file,
+		offset: offset,
+		added:  c.consumer.Added(),
+	})
+}
+
+// locate returns the position of the record which was the nth added.
+func (c *checkpointer) locate(n int) position {
+	var p position
+	for _, f := range c.files {
+		if f.added > n {
+			break
+		}
+		p = f
+	}
+	p.offset += n - p.added
+	p.added = n
+	return p
+}
+
+// hold keeps the checkpoint at the documents which have failed since the
+// last save. Failures are matched to the records added before the flush;
+// any which cannot be matched yet are tried again at the next save.
+func (c *checkpointer) hold(n int) {
+	added := c.consumer.Take(n)
+	failures := c.client.Failures()
+	ids := c.missing
+	c.missing = nil
+	for _, f := range failures[c.seen:] {
+		ids = append(ids, f.Identifier)
+	}
+	c.seen = len(failures)
+	for _, id := range ids {
+		pos, ok := added[id]
+		if !ok {
+			c.missing = append(c.missing, id)
+			continue
+		}
+		p := c.locate(pos)
+		if c.held == nil || p.n < c.held.n || (p.n == c.held.n && p.offset < c.held.offset) {
+			c.held = &p
+		}
+	}
+}
+
+// save writes a checkpoint covering every record the consumer has added
+// so far, or up to the first document which failed. Unless the client
+// has already been stopped, outstanding bulk requests are flushed first
+// so the checkpoint never runs ahead of the index.
+func (c *checkpointer) save(flush bool, complete bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.consumer.Added()
+	if flush {
+		err := c.client.Flush()
+		if err != nil {
+			return err
+		}
+	}
+	c.hold(n)
+	cp := c.start
+	if len(c.files) > 0 {
+		p := c.locate(n)
+		if c.held != nil {
+			p = *c.held
+		}
+		cp.File = p.file
+		cp.Offset = p.offset
+	}
+	cp.Ingested += n
+	cp.Rejected += c.rejects.Total()
+	cp.Complete = complete && c.held == nil
+	cp.Updated = time.Now().UTC()
+	return cp.Save(c.path)
+}
+
+// every saves a checkpoint each interval until stop is closed. The
+// returned channel is closed once it has finished.
+func (c *checkpointer) every(interval time.Duration, stop <-chan struct{}) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := c.save(true, false); err != nil {
+					log.Println("Could not save checkpoint:", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return done
+}
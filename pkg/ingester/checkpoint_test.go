@@ -0,0 +1,266 @@
+package ingester
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mitlibraries/mario/pkg/client"
+	"github.com/mitlibraries/mario/pkg/consumer"
+	"github.com/mitlibraries/mario/pkg/record"
+)
+
+type bulkIndexer struct {
+	client.Indexer
+	created  []string
+	promoted []string
+	failures []client.BulkFailure
+}
+
+func (b *bulkIndexer) Start() error {
+	return nil
+}
+
+func (b *bulkIndexer) Stop() error {
+	return nil
+}
+
+func (b *bulkIndexer) Current(prefix string) (string, error) {
+	return "", nil
+}
+
+func (b *bulkIndexer) Promote(index string, prefix string) error {
+	b.promoted = append(b.promoted, index)
+	return nil
+}
+
+func (b *bulkIndexer) Create(index string) error {
+	b.created = append(b.created, index)
+	return nil
+}
+
+func (b *bulkIndexer) Flush() error {
+	return nil
+}
+
+func (b *bulkIndexer) Failures() []client.BulkFailure {
+	return b.failures
+}
+
+func (b *bulkIndexer) Add(r record.Record, index string, rtype string) error {
+	return nil
+}
+
+func (b *bulkIndexer) Remove(id string, index string, rtype string) error {
+	return nil
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "mario")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func consume(es *consumer.ESConsumer, ids ...string) {
+	in := make(chan record.Record)
+	out := es.Consume(context.Background(), in, nil)
+	for _, id := range ids {
+		in <- record.Record{Identifier: id}
+	}
+	close(in)
+	<-out
+}
+
+func TestCheckpointSaveAndLoad(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint.json")
+	cp := Checkpoint{
+		Filename: "s3://bucket/aleph/",
+		Index:    "aleph-2019-01-01t00-00-00z",
+		File:     "s3://bucket/aleph/b.mrc",
+		Offset:   10,
+		Ingested: 110,
+		Rejected: 2,
+		Updated:  time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	err := cp.Save(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Error("Expected no temporary file to be left, got", err)
+	}
+	loaded, err := LoadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *loaded != cp {
+		t.Errorf("Expected %+v, got %+v", cp, *loaded)
+	}
+	_, err = LoadCheckpoint(filepath.Join(dir, "missing.json"))
+	if err == nil {
+		t.Error("Expected an error loading a missing checkpoint")
+	}
+}
+
+func TestConfigureResume(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"a.json", "b.json"} {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte("[]"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(dir, "checkpoint.json")
+	index := "aleph-2019-01-01t00-00-00z"
+	valid := Checkpoint{
+		Filename: dir,
+		Index:    index,
+		File:     filepath.Join(dir, "b.json"),
+		Offset:   5,
+		Ingested: 25,
+	}
+
+	var tests = []struct {
+		name   string
+		change func(cp *Checkpoint, config *Config)
+		err    string
+	}{
+		{"no checkpoint", func(cp *Checkpoint, config *Config) { config.Checkpoint = "" }, "needs a checkpoint"},
+		{"missing", func(cp *Checkpoint, config *Config) { config.Checkpoint = filepath.Join(dir, "missing.json") }, "Could not load"},
+		{"complete", func(cp *Checkpoint, config *Config) { cp.Complete = true }, "already completed"},
+		{"other filename", func(cp *Checkpoint, config *Config) { cp.Filename = "other" }, "is for other"},
+		{"other index", func(cp *Checkpoint, config *Config) { config.Index = "aleph-new" }, "is for index"},
+		{"file not found", func(cp *Checkpoint, config *Config) { cp.File = filepath.Join(dir, "c.json") }, "was not found"},
+	}
+	for _, tt := range tests {
+		cp := valid
+		config := Config{
+			Filename:   dir,
+			Source:     "json",
+			Consumer:   "es",
+			Prefix:     "aleph",
+			Checkpoint: path,
+			Resume:     true,
+		}
+		tt.change(&cp, &config)
+		if err := cp.Save(path); err != nil {
+			t.Fatal(err)
+		}
+		i := Ingester{Client: &bulkIndexer{}}
+		err := i.Configure(config)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: expected an error containing %q, got %v", tt.name, tt.err, err)
+		}
+	}
+
+	if err := valid.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	c := &bulkIndexer{}
+	i := Ingester{Client: c}
+	err := i.Configure(Config{
+		Filename:   dir,
+		Source:     "json",
+		Consumer:   "es",
+		Prefix:     "aleph",
+		Checkpoint: path,
+		Resume:     true,
+		Rejects:    filepath.Join(dir, "rejects.json"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.first != 1 || i.skip != 5 {
+		t.Errorf("Expected to resume at file 1 offset 5, got %d %d", i.first, i.skip)
+	}
+	if i.config.Index != index || len(c.created) != 1 || c.created[0] != index {
+		t.Error("Expected to resume into", index, "got", i.config.Index, c.created)
+	}
+	if i.config.Rejects != filepath.Join(dir, "rejects.json.25") {
+		t.Error("Expected rejects to be suffixed with 25, got", i.config.Rejects)
+	}
+}
+
+func TestCheckpointerSavesPeriodically(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint.json")
+	c := &bulkIndexer{}
+	es := &consumer.ESConsumer{Index: "aleph-new", Client: c, Track: true}
+	saver := &checkpointer{
+		path:     path,
+		start:    Checkpoint{Filename: dir, Index: "aleph-new", Ingested: 10},
+		consumer: es,
+		client:   c,
+		rejects:  &rejectTally{},
+	}
+	saver.next("a.json", 0)
+	consume(es, "a1", "a2", "a3")
+	saver.next("b.json", 2)
+	consume(es, "b3", "b4")
+
+	stop := make(chan struct{})
+	done := saver.every(10*time.Millisecond, stop)
+	var cp *Checkpoint
+	var err error
+	for n := 0; n < 100 && cp == nil; n++ {
+		time.Sleep(10 * time.Millisecond)
+		cp, err = LoadCheckpoint(path)
+	}
+	close(stop)
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.File != "b.json" || cp.Offset != 4 || cp.Ingested != 15 || cp.Complete {
+		t.Errorf("Expected b.json at 4 with 15 ingested, got %+v", cp)
+	}
+}
+
+func TestCheckpointerHoldsAtFailures(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint.json")
+	c := &bulkIndexer{}
+	es := &consumer.ESConsumer{Index: "aleph-new", Client: c, Track: true}
+	saver := &checkpointer{
+		path:     path,
+		consumer: es,
+		client:   c,
+		rejects:  &rejectTally{},
+	}
+	saver.next("a.json", 0)
+	consume(es, "a1", "a2", "a3")
+	saver.next("b.json", 0)
+	consume(es, "b1", "b2")
+	c.failures = []client.BulkFailure{{Identifier: "b2"}, {Identifier: "a2"}}
+
+	err := saver.save(true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	consume(es, "b3")
+	err = saver.save(false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp, err := LoadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.File != "a.json" || cp.Offset != 1 {
+		t.Errorf("Expected to hold at a.json offset 1, got %s %d", cp.File, cp.Offset)
+	}
+	if cp.Complete {
+		t.Error("Expected a checkpoint with failures not to be complete")
+	}
+}
@@ -0,0 +1,67 @@
+package ingester
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mitlibraries/mario/pkg/client"
+)
+
+// Expand returns the files to ingest for a path, in the order they should
+// be ingested. The path can be:
+//
+//   - a local file or S3 object
+//   - a local glob, such as data/aleph/*.mrc
+//   - a local directory, whose files are all ingested
+//   - an S3 prefix ending in a slash, such as s3://bucket/prod/aleph/,
+//     whose objects are all ingested
+//
+// Files are sorted by name. It is an error for a glob, directory or
+// prefix to match nothing.
+func Expand(path string) ([]string, error) {
+	parts, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	if parts.Scheme == "s3" {
+		if !strings.HasSuffix(parts.Path, "/") {
+			return []string{path}, nil
+		}
+		keys, err := client.ListS3Objs(parts.Host, strings.TrimPrefix(parts.Path, "/"))
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if !strings.HasSuffix(key, "/") {
+				files = append(files, fmt.Sprintf("s3://%s/%s", parts.Host, key))
+			}
+		}
+	} else if strings.ContainsAny(path, "*?[") {
+		files, err = filepath.Glob(path)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			// Not a directory, so leave it to the stream to report
+			// whether the file exists.
+			return []string{path}, nil
+		}
+		for _, e := range entries {
+			if !e.IsDir() {
+				files = append(files, filepath.Join(path, e.Name()))
+			}
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("No files found for %s", path)
+	}
+	sort.Strings(files)
+	return files, nil
+}
@@ -0,0 +1,54 @@
+package ingester
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mario")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"b.mrc", "a.mrc", "c.xml"} {
+		err = ioutil.WriteFile(filepath.Join(dir, name), nil, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		path     string
+		expected []string
+	}{
+		{filepath.Join(dir, "*.mrc"), []string{"a.mrc", "b.mrc"}},
+		{dir, []string{"a.mrc", "b.mrc", "c.xml"}},
+		{filepath.Join(dir, "c.xml"), []string{"c.xml"}},
+	}
+	for _, tt := range tests {
+		files, err := Expand(tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expected []string
+		for _, name := range tt.expected {
+			expected = append(expected, filepath.Join(dir, name))
+		}
+		if !reflect.DeepEqual(files, expected) {
+			t.Errorf("Expected %v, got %v", expected, files)
+		}
+	}
+
+	_, err = Expand(filepath.Join(dir, "*.json"))
+	if err == nil {
+		t.Error("Expected an error for a glob matching nothing")
+	}
+}
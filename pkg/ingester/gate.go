@@ -0,0 +1,84 @@
+package ingester
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mitlibraries/mario/pkg/client"
+)
+
+// ErrGate is returned when a new index fails the checks which are run
+// before it is promoted.
+var ErrGate = errors.New("Quality gate failed")
+
+// Gate holds the checks a new index must pass before an ingest promotes
+// it. The index must have no bulk failures. If MinRatio is set it must
+// have at least that share of the documents in the index it replaces on
+// the primary alias. Each of the Queries, in the query string syntax,
+// must match at least one document.
+type Gate struct {
+	MinRatio float64
+	Queries  []string
+}
+
+// Check is the outcome of one of the Gate checks.
+type Check struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail"`
+}
+
+// Run the checks against index, which is to replace current on the
+// primary alias. current is empty if there is no index to replace, in
+// which case the document counts are not compared. An error wrapping
+// ErrGate names the checks which failed.
+func (g Gate) Run(c client.Indexer, index string, current string, failed int) ([]Check, error) {
+	var checks []Check
+	checks = append(checks, Check{
+		Name:   "failures",
+		Passed: failed == 0,
+		Detail: fmt.Sprintf("%d documents failed to index", failed),
+	})
+	if g.MinRatio > 0 && current != "" && current != index {
+		count, err := c.Count(index)
+		if err != nil {
+			return checks, err
+		}
+		previous, err := c.Count(current)
+		if err != nil {
+			return checks, err
+		}
+		check := Check{
+			Name:   "count",
+			Passed: previous == 0 || float64(count) >= g.MinRatio*float64(previous),
+			Detail: fmt.Sprintf("%s has %d documents, %s has %d", index, count, current, previous),
+		}
+		if previous > 0 {
+			check.Detail += fmt.Sprintf(" (%.1f%%, minimum %.1f%%)",
+				100*float64(count)/float64(previous), 100*g.MinRatio)
+		}
+		checks = append(checks, check)
+	}
+	for _, q := range g.Queries {
+		hits, err := c.Hits(index, q)
+		if err != nil {
+			return checks, err
+		}
+		checks = append(checks, Check{
+			Name:   "query",
+			Passed: hits > 0,
+			Detail: fmt.Sprintf("%q matched %d documents", q, hits),
+		})
+	}
+	var failures []string
+	for _, check := range checks {
+		if !check.Passed {
+			failures = append(failures, fmt.Sprintf("%s: %s", check.Name, check.Detail))
+		}
+	}
+	if len(failures) > 0 {
+		return checks, fmt.Errorf("%w: %s", ErrGate, strings.Join(failures, "; "))
+	}
+	return checks, nil
+}
@@ -0,0 +1,100 @@
+package ingester
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mitlibraries/mario/pkg/client"
+)
+
+type fakeIndexer struct {
+	client.Indexer
+	counts map[string]int64
+	hits   map[string]int64
+}
+
+func (f fakeIndexer) Count(index string) (int64, error) {
+	return f.counts[index], nil
+}
+
+func (f fakeIndexer) Hits(index string, query string) (int64, error) {
+	return f.hits[query], nil
+}
+
+func TestGatePasses(t *testing.T) {
+	c := fakeIndexer{
+		counts: map[string]int64{"aleph-new": 95, "aleph-old": 100},
+		hits:   map[string]int64{"title:physics": 3},
+	}
+	g := Gate{MinRatio: 0.9, Queries: []string{"title:physics"}}
+	checks, err := g.Run(c, "aleph-new", "aleph-old", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checks) != 3 {
+		t.Error("Expected 3 checks, got", len(checks))
+	}
+}
+
+func TestGateFails(t *testing.T) {
+	c := fakeIndexer{
+		counts: map[string]int64{"aleph-new": 50, "aleph-old": 100},
+		hits:   map[string]int64{"title:physics": 3},
+	}
+	g := Gate{MinRatio: 0.9, Queries: []string{"title:physics", "title:nothing"}}
+	checks, err := g.Run(c, "aleph-new", "aleph-old", 2)
+	if !errors.Is(err, ErrGate) {
+		t.Fatal("Expected ErrGate, got", err)
+	}
+	var failed []string
+	for _, check := range checks {
+		if !check.Passed {
+			failed = append(failed, check.Name)
+		}
+	}
+	if strings.Join(failed, ",") != "failures,count,query" {
+		t.Error("Unexpected failed checks", failed)
+	}
+}
+
+func TestGateSkipsCountWithoutCurrent(t *testing.T) {
+	c := fakeIndexer{counts: map[string]int64{"aleph-new": 1}}
+	checks, err := Gate{MinRatio: 0.9}.Run(c, "aleph-new", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checks) != 1 || checks[0].Name != "failures" {
+		t.Error("Expected only the failures check, got", checks)
+	}
+}
+
+func TestIngestWithBulkFailuresIsNotGated(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "records.json")
+	err := ioutil.WriteFile(file, []byte(`[{"identifier": "1", "title": "Microfluids"}]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &bulkIndexer{failures: []client.BulkFailure{{Identifier: "1", Status: 400}}}
+	i := Ingester{Client: c}
+	err = i.Configure(Config{Filename: file, Source: "json", Consumer: "es", Prefix: "aleph", Promote: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := i.Ingest(context.Background())
+	if !errors.Is(err, ErrBulkFailures) || errors.Is(err, ErrGate) {
+		t.Error("Expected ErrBulkFailures rather than ErrGate, got", err)
+	}
+	if report.Promoted || len(c.promoted) != 0 {
+		t.Error("Expected the index not to be promoted")
+	}
+	if len(report.Checks) == 0 || report.Checks[0].Passed {
+		t.Error("Expected the failures check to be reported, got", report.Checks)
+	}
+}
@@ -1,6 +1,7 @@
 package ingester
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/mitlibraries/mario/pkg/client"
@@ -9,22 +10,53 @@ import (
 	"github.com/mitlibraries/mario/pkg/pipeline"
 	"github.com/mitlibraries/mario/pkg/transformer"
 	"io"
+	"log"
 	"net/url"
 	"os"
 	"strings"
 	"time"
 )
 
+// ErrBulkFailures is returned by Ingest when Elasticsearch did not accept
+// every document.
+var ErrBulkFailures = errors.New("Some documents could not be indexed")
+
 // Config is a structure for passing a set of configuration parameters to
-// an Ingester.
+// an Ingester. Source is the type of data in the file. Config.Route can
+// fill it in, along with Prefix and Rulesfile, from where the file is
+// kept, which for local files means under DataRoot. Configure then sets
+// Incremental if the files are updates from that source, which are added
+// to the current index for Prefix instead of a new one.
+//
+// If Checkpoint is set, a Checkpoint is saved there every
+// CheckpointInterval and when the ingest ends. Checkpoints need the es
+// consumer and keep records in input order. With Resume, the ingest
+// carries on from the Checkpoint instead, writing into the same index.
+// Records rejected after resuming are written to Rejects with the number
+// of records ingested before resuming appended, so that earlier rejects
+// are kept.
 type Config struct {
-	Filename  string
-	Source    string
-	Consumer  string
-	Index     string
-	Prefix    string
-	Promote   bool
-	Rulesfile string
+	Filename           string
+	DataRoot           string
+	Environment        string
+	Alias              string
+	Source             string
+	Consumer           string
+	Index              string
+	Prefix             string
+	Promote            bool
+	Rulesfile          string
+	FailFast           bool
+	Workers            int
+	Ordered            bool
+	Rejects            string
+	Checkpoint         string
+	CheckpointInterval time.Duration
+	Resume             bool
+	Incremental        bool
+	Gate               Gate
+	Harvest            generator.Harvest
+	source             Source
 }
 
 // NewStream returns an io.ReadCloser from a path string. The path can be
@@ -40,40 +72,165 @@ func NewStream(filename string) (io.ReadCloser, error) {
 	return os.Open(filename)
 }
 
-// Ingester does the work of ingesting a data stream.
+// NewSink returns an io.WriteCloser for a path string. The path can be
+// either a local file path or a URL for an S3 object. Any existing file
+// or object will be replaced.
+func NewSink(filename string) (io.WriteCloser, error) {
+	parts, err := url.Parse(filename)
+	if err != nil {
+		return nil, err
+	}
+	if parts.Scheme == "s3" {
+		return client.PutS3Obj(parts.Host, strings.TrimPrefix(parts.Path, "/"))
+	}
+	return os.Create(filename)
+}
+
+// Ingester does the work of ingesting one or more files into the same
+// index.
 type Ingester struct {
-	Stream    io.ReadCloser
-	config    Config
-	generator pipeline.Generator
-	consumer  pipeline.Consumer
-	Client    client.Indexer
+	config   Config
+	files    []string
+	first    int
+	skip     int
+	consumer pipeline.Consumer
+	rejects  *rejectSink
+	tally    *rejectTally
+	saver    *checkpointer
+	Client   client.Indexer
 }
 
-// Configure an Ingester. This should be called before Ingest.
-func (i *Ingester) Configure(config Config) error {
-	var err error
-	// Configure generator
+// oaiFormats are the sources used to map each OAI-PMH metadata format.
+var oaiFormats = map[string]string{
+	"oai_ead": "archives",
+	"oai_dc":  "dc",
+	"qdc":     "dc",
+	"marc21":  "marcxml",
+	"marcxml": "marcxml",
+	"mods":    "mods",
+}
+
+// newGenerator returns a generator for the configured type of data which
+// reads from stream, skipping the first skip records.
+func newGenerator(config Config, stream io.Reader, skip int) (pipeline.Generator, error) {
 	if config.Source == "json" {
-		i.generator = &generator.JSONGenerator{File: i.Stream}
-	} else if config.Source == "marc" {
-		i.generator = &generator.MarcGenerator{
-			Marcfile:  i.Stream,
+		return &generator.JSONGenerator{File: stream, Skip: skip}, nil
+	} else if config.Source == "marc" || config.Source == "marcxml" || config.Source == "mrk" || config.Source == "marcjson" {
+		return &generator.MarcGenerator{
+			Marcfile:  stream,
 			Rulesfile: config.Rulesfile,
-		}
+			Format:    config.Source,
+			Workers:   config.Workers,
+			Ordered:   config.Ordered,
+			Skip:      skip,
+		}, nil
 	} else if config.Source == "archives" {
-		i.generator = &generator.ArchivesGenerator{Archivefile: i.Stream}
+		return &generator.ArchivesGenerator{Archivefile: stream, Skip: skip}, nil
+	} else if config.Source == "dc" {
+		return &generator.DublinCoreGenerator{File: stream, Skip: skip}, nil
+	} else if config.Source == "mods" {
+		return &generator.ModsGenerator{File: stream, Skip: skip}, nil
+	} else if config.Source == "oai" {
+		format, ok := oaiFormats[config.Harvest.MetadataPrefix]
+		if !ok {
+			return nil, fmt.Errorf("No mapping for OAI-PMH metadata format %s", config.Harvest.MetadataPrefix)
+		}
+		mapping := config
+		mapping.Source = format
+		harvest := config.Harvest
+		harvest.BaseURL = config.Filename
+		return &generator.OAIGenerator{
+			Harvest: harvest,
+			Mapping: func(page io.Reader) pipeline.Generator {
+				g, _ := newGenerator(mapping, page, 0)
+				return g
+			},
+			Retries: 5,
+			Backoff: time.Second,
+			Skip:    skip,
+		}, nil
+	}
+	return nil, errors.New("Unknown source data")
+}
+
+// Configure an Ingester. This should be called before Ingest. The
+// Filename in the config is expanded into the files to ingest, see Expand.
+func (i *Ingester) Configure(config Config) error {
+	var err error
+	start := Checkpoint{Filename: config.Filename}
+	if config.Checkpoint != "" {
+		if config.Consumer != "es" {
+			return errors.New("Checkpoints can only be used with the es consumer")
+		}
+		config.Ordered = true
+		if config.CheckpointInterval <= 0 {
+			config.CheckpointInterval = time.Minute
+		}
+	}
+
+	// Check the generator can be configured before looking for files
+	_, err = newGenerator(config, nil, 0)
+	if err != nil {
+		return err
+	}
+	if config.Source == "oai" {
+		i.files = []string{config.Filename}
 	} else {
-		return errors.New("Unknown source data")
+		i.files, err = Expand(config.Filename)
+		if err != nil {
+			return err
+		}
+	}
+	config.Incremental, err = incremental(config.source, i.files)
+	if err != nil {
+		return err
+	}
+	i.first = 0
+	i.skip = 0
+
+	if config.Resume {
+		if config.Checkpoint == "" {
+			return errors.New("Resuming an ingest needs a checkpoint")
+		}
+		cp, err := LoadCheckpoint(config.Checkpoint)
+		if err != nil {
+			return fmt.Errorf("Could not load checkpoint: %w", err)
+		}
+		if cp.Complete {
+			return fmt.Errorf("Checkpoint %s is for an ingest that has already completed", config.Checkpoint)
+		}
+		if cp.Filename != config.Filename {
+			return fmt.Errorf("Checkpoint %s is for %s, not %s", config.Checkpoint, cp.Filename, config.Filename)
+		}
+		if config.Index != "" && config.Index != cp.Index {
+			return fmt.Errorf("Checkpoint %s is for index %s, not %s", config.Checkpoint, cp.Index, config.Index)
+		}
+		i.first = -1
+		for n, f := range i.files {
+			if f == cp.File {
+				i.first = n
+			}
+		}
+		if i.first < 0 {
+			return fmt.Errorf("Checkpoint %s is for %s, which was not found", config.Checkpoint, cp.File)
+		}
+		i.skip = cp.Offset
+		config.Index = cp.Index
+		if config.Incremental {
+			config.Promote = false
+		}
+		if config.Rejects != "" {
+			config.Rejects = fmt.Sprintf("%s.%d", config.Rejects, cp.Ingested)
+		}
+		start = *cp
 	}
 
 	// Configure consumer
 	if config.Consumer == "es" {
-		// This block relies on certain file naming conventions to work. Daily
-		// updates to aleph have the string mit01_edsu1 in the filename. If that
-		// string is present we will add the records to the current aleph index
-		// instead of creating a new index.
+		// If the file is an incremental update we will add the records to
+		// the current index instead of creating a new index.
 		if config.Index == "" {
-			if strings.Contains(config.Filename, "mit01_edsu1") {
+			if config.Incremental {
 				current, err := i.Client.Current(config.Prefix)
 				if err != nil || current == "" {
 					return errors.New("Could not determine current index")
@@ -82,7 +239,7 @@ func (i *Ingester) Configure(config Config) error {
 				config.Promote = false
 			} else {
 				now := time.Now().UTC()
-				config.Index = fmt.Sprintf("%s-%s", config.Prefix, now.Format("2006-01-02t15-04-05z"))
+				config.Index = fmt.Sprintf("%s-%s", config.Prefix, now.Format(client.IndexTimeFormat))
 			}
 		}
 
@@ -90,10 +247,21 @@ func (i *Ingester) Configure(config Config) error {
 		if err != nil {
 			return err
 		}
-		i.consumer = &consumer.ESConsumer{
+		es := &consumer.ESConsumer{
 			Index:  config.Index,
 			RType:  "Record",
 			Client: i.Client,
+			Track:  config.Checkpoint != "",
+		}
+		i.consumer = es
+		start.Index = config.Index
+		if config.Checkpoint != "" {
+			i.saver = &checkpointer{
+				path:     config.Checkpoint,
+				start:    start,
+				consumer: es,
+				client:   i.Client,
+			}
 		}
 	} else if config.Consumer == "json" {
 		i.consumer = &consumer.JSONConsumer{Out: os.Stdout}
@@ -105,32 +273,180 @@ func (i *Ingester) Configure(config Config) error {
 		return errors.New("Unknown consumer")
 	}
 
+	i.tally = &rejectTally{}
+	if config.Rejects != "" {
+		i.rejects = &rejectSink{path: config.Rejects}
+		i.tally.sink = i.rejects
+	}
+	if i.saver != nil {
+		i.saver.rejects = i.tally
+	}
+
 	i.config = config
 	return nil
 }
 
-// Ingest the configured data stream. The Ingester should have been
-// configured before calling this method. It will return the number of
-// ingested documents.
-func (i *Ingester) Ingest() (int, error) {
-	var err error
-	p := pipeline.Pipeline{
-		Generator: i.generator,
-		Consumer:  i.consumer,
+// Ingest the configured files one after another. The Ingester should
+// have been configured before calling this method. It returns a Report of
+// the run, even when the ingest fails.
+//
+// If the context is cancelled the generator stops reading, records
+// already in the pipeline are drained and flushed to the index, and the
+// index is not promoted. The returned error will wrap the context's error.
+//
+// Records which could not be processed are logged and skipped unless
+// Config.FailFast is set. If Config.Rejects is set they are also written
+// there, along with the reason they were rejected. A fatal error, or any
+// record error when failing fast, stops the ingest before the next file.
+// It is returned as a *pipeline.Errors and the index is not promoted.
+//
+// Documents Elasticsearch would not accept, even after retrying, are
+// logged and listed in the Report. The index is not promoted and the
+// returned error wraps ErrBulkFailures.
+//
+// When checkpointing, a final Checkpoint is saved however the ingest
+// ends. It is marked complete if the ingest succeeded.
+func (i *Ingester) Ingest(ctx context.Context) (report *Report, err error) {
+	report = &Report{
+		Filename: i.config.Filename,
+		Index:    i.config.Index,
+		Started:  time.Now().UTC(),
+	}
+	defer func() {
+		report.finish(err)
+	}()
+	if c, ok := i.consumer.(io.Closer); ok {
+		defer func() {
+			if cerr := c.Close(); cerr != nil && err == nil {
+				err = fmt.Errorf("Could not write records: %w", cerr)
+			}
+		}()
+	}
+	if i.rejects != nil {
+		defer func() {
+			if cerr := i.rejects.Close(); cerr != nil {
+				log.Println("Could not close rejected records:", cerr)
+			}
+		}()
 	}
-	ctr := &transformer.Counter{}
-	p.Next(ctr)
 	if i.config.Consumer == "es" {
 		err = i.Client.Start()
 		if err != nil {
-			return 0, err
+			return report, err
+		}
+	}
+	var stop chan struct{}
+	var saving <-chan struct{}
+	if i.saver != nil {
+		stop = make(chan struct{})
+		saving = i.saver.every(i.config.CheckpointInterval, stop)
+	}
+	var runErr error
+	var count int
+	for n := i.first; n < len(i.files) && runErr == nil && ctx.Err() == nil; n++ {
+		skip := 0
+		if n == i.first {
+			skip = i.skip
+		}
+		if i.saver != nil {
+			i.saver.next(i.files[n], skip)
+		}
+		var fr FileReport
+		fr, runErr = i.ingestFile(ctx, i.files[n], skip)
+		report.Files = append(report.Files, fr)
+		count += fr.Read
+	}
+	if i.saver != nil {
+		close(stop)
+		<-saving
+	}
+	if i.config.Consumer == "es" {
+		// Stopping the bulk processor flushes any outstanding requests. This
+		// is done on cancellation as well so that every record which made it
+		// through the pipeline ends up in the index.
+		err = i.Client.Stop()
+		if err != nil {
+			return report, err
+		}
+		report.Failures = i.Client.Failures()
+		report.Failed = len(report.Failures)
+		for _, f := range report.Failures {
+			log.Printf("Could not index %s in %s: %s: %s", f.Identifier, f.Index, f.Type, f.Reason)
+		}
+	}
+	if i.saver != nil {
+		cerr := i.saver.save(false, runErr == nil && ctx.Err() == nil)
+		if cerr != nil {
+			log.Println("Could not save checkpoint:", cerr)
 		}
-		defer i.Client.Stop()
 	}
-	out := p.Run()
-	<-out
+	if runErr != nil {
+		return report, runErr
+	}
+	if ctx.Err() != nil {
+		return report, fmt.Errorf("Ingest stopped after %d records: %w", count, ctx.Err())
+	}
 	if i.config.Promote {
+		// The gate checks for bulk failures itself, so that they are
+		// listed along with any other checks which failed, but they are
+		// returned as bulk failures like they are without promoting.
+		var current string
+		current, err = i.Client.Current(i.config.Prefix)
+		if err != nil {
+			return report, err
+		}
+		report.Checks, err = i.config.Gate.Run(i.Client, i.config.Index, current, report.Failed)
+		if report.Failed > 0 {
+			return report, fmt.Errorf("%w: %d failed, not promoting: %v", ErrBulkFailures, report.Failed, err)
+		}
+		if err != nil {
+			return report, err
+		}
 		err = i.Client.Promote(i.config.Index, i.config.Prefix)
+		report.Promoted = err == nil || errors.Is(err, client.ErrHistory)
+		return report, err
+	}
+	if report.Failed > 0 {
+		return report, fmt.Errorf("%w: %d failed", ErrBulkFailures, report.Failed)
+	}
+	return report, nil
+}
+
+// ingestFile runs the records in one file through a pipeline into the
+// consumer. An error is only returned if the ingest should stop.
+func (i *Ingester) ingestFile(ctx context.Context, file string, skip int) (FileReport, error) {
+	fr := FileReport{Filename: file}
+	var stream io.ReadCloser
+	if i.config.Source != "oai" {
+		var err error
+		stream, err = NewStream(file)
+		if err != nil {
+			return fr, &pipeline.Errors{Fatal: err}
+		}
+		defer stream.Close()
+	}
+	gen, err := newGenerator(i.config, stream, skip)
+	if err != nil {
+		return fr, &pipeline.Errors{Fatal: err}
+	}
+	p := pipeline.Pipeline{
+		Generator: gen,
+		Consumer:  i.consumer,
+		FailFast:  i.config.FailFast,
+		Rejects:   i.tally,
+	}
+	ctr := &transformer.Counter{}
+	p.Next(&pipeline.Pool{Transformer: ctr, Workers: i.config.Workers, Ordered: i.config.Ordered})
+	before := i.tally.Counts()
+	runErr := p.Run(ctx)
+	fr.count(ctr, before, i.tally.Counts())
+
+	var perr *pipeline.Errors
+	if errors.As(runErr, &perr) {
+		log.Printf("Error records in %s: %d", file, perr.Total())
+		if perr.Fatal != nil || i.config.FailFast {
+			return fr, perr
+		}
 	}
-	return ctr.Count, err
+	return fr, nil
 }
@@ -0,0 +1,97 @@
+package ingester
+
+import (
+	"io"
+	"log"
+	"sync"
+
+	"github.com/mitlibraries/mario/pkg/consumer"
+	"github.com/mitlibraries/mario/pkg/pipeline"
+)
+
+// rejectSink writes rejected records to path and the reasons they were
+// rejected to path.jsonl. Nothing is created until the first record is
+// rejected, so runs without rejects leave nothing behind.
+type rejectSink struct {
+	path    string
+	writer  *consumer.RejectWriter
+	data    io.WriteCloser
+	reasons io.WriteCloser
+}
+
+// Reject writes a rejected record, opening the outputs if needed.
+func (s *rejectSink) Reject(e *pipeline.RecordError) error {
+	if s.writer == nil {
+		var err error
+		s.data, err = NewSink(s.path)
+		if err != nil {
+			return err
+		}
+		s.reasons, err = NewSink(s.path + ".jsonl")
+		if err != nil {
+			return err
+		}
+		s.writer = &consumer.RejectWriter{Data: s.data, Reasons: s.reasons}
+	}
+	return s.writer.Reject(e)
+}
+
+// Close the outputs, if any were opened.
+func (s *rejectSink) Close() error {
+	var err error
+	for _, w := range []io.WriteCloser{s.data, s.reasons} {
+		if w == nil {
+			continue
+		}
+		if cerr := w.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// rejectTally logs and counts rejected records by reason before passing
+// them on to sink, if there is one.
+type rejectTally struct {
+	mu     sync.Mutex
+	counts map[string]int
+	sink   pipeline.Rejecter
+}
+
+// Reject counts a rejected record and writes it to the sink.
+func (t *rejectTally) Reject(e *pipeline.RecordError) error {
+	log.Println(e)
+	t.mu.Lock()
+	if t.counts == nil {
+		t.counts = make(map[string]int)
+	}
+	t.counts[e.Reason]++
+	t.mu.Unlock()
+	if t.sink == nil {
+		return nil
+	}
+	return t.sink.Reject(e)
+}
+
+// Counts returns a copy of the number of records rejected for each
+// reason.
+func (t *rejectTally) Counts() map[string]int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	counts := make(map[string]int, len(t.counts))
+	for reason, c := range t.counts {
+		counts[reason] = c
+	}
+	return counts
+}
+
+// Total returns the number of records rejected so far.
+func (t *rejectTally) Total() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var n int
+	for _, c := range t.counts {
+		n += c
+	}
+	return n
+}
@@ -0,0 +1,121 @@
+package ingester
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/mitlibraries/mario/pkg/client"
+	"github.com/mitlibraries/mario/pkg/pipeline"
+	"github.com/mitlibraries/mario/pkg/transformer"
+)
+
+// Report summarises an ingest run, with counts for each file ingested.
+//
+// Read counts every record taken from the input, whether it was indexed,
+// deleted or rejected. Indexed excludes deleted records, and any documents
+// the index failed to accept, which are counted in Failed and listed in
+// Failures. Checks are the outcome of the Gate, which is only run when the
+// index is to be promoted.
+type Report struct {
+	Filename   string               `json:"filename"`
+	Index      string               `json:"index,omitempty"`
+	Files      []FileReport         `json:"files"`
+	Read       int                  `json:"read"`
+	Indexed    int                  `json:"indexed"`
+	Rejected   map[string]int       `json:"rejected"`
+	Deleted    int                  `json:"deleted"`
+	Failed     int                  `json:"failed"`
+	Failures   []client.BulkFailure `json:"failures,omitempty"`
+	Started    time.Time            `json:"started"`
+	Finished   time.Time            `json:"finished"`
+	Seconds    float64              `json:"seconds"`
+	PerSecond  float64              `json:"records_per_second"`
+	Checks     []Check              `json:"checks,omitempty"`
+	Promoted   bool                 `json:"promoted"`
+	Error      string               `json:"error,omitempty"`
+	Successful bool                 `json:"successful"`
+}
+
+// FileReport counts the records from one file. Bulk failures are only
+// counted for the whole run, so Indexed includes any documents from the
+// file which failed.
+type FileReport struct {
+	Filename string         `json:"filename"`
+	Read     int            `json:"read"`
+	Indexed  int            `json:"indexed"`
+	Rejected map[string]int `json:"rejected"`
+	Deleted  int            `json:"deleted"`
+}
+
+// count fills in the counts for a file. ctr has counted the records which
+// made it through the pipeline, and before and after are the number of
+// records rejected for each reason before and after the file. Records
+// the consumer could not encode were rejected after ctr counted them, so
+// they are taken off Indexed rather than added to Read.
+func (f *FileReport) count(ctr *transformer.Counter, before map[string]int, after map[string]int) {
+	f.Rejected = make(map[string]int)
+	for reason, n := range after {
+		if n > before[reason] {
+			f.Rejected[reason] = n - before[reason]
+		}
+	}
+	f.Deleted = ctr.Deleted
+	f.Read = ctr.Count
+	f.Indexed = ctr.Count - ctr.Deleted
+	for reason, n := range f.Rejected {
+		if reason == pipeline.ReasonEncode {
+			f.Indexed -= n
+		} else {
+			f.Read += n
+		}
+	}
+}
+
+// finish totals the counts for each file and fills in the timings once
+// the run has ended.
+func (r *Report) finish(err error) {
+	r.Finished = time.Now().UTC()
+	r.Seconds = r.Finished.Sub(r.Started).Seconds()
+	r.Rejected = make(map[string]int)
+	for _, f := range r.Files {
+		r.Read += f.Read
+		r.Indexed += f.Indexed
+		r.Deleted += f.Deleted
+		for reason, n := range f.Rejected {
+			r.Rejected[reason] += n
+		}
+	}
+	r.Indexed -= r.Failed
+	if r.Seconds > 0 {
+		r.PerSecond = float64(r.Read) / r.Seconds
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	r.Successful = err == nil
+}
+
+// Write the report as JSON to a local file, an S3 object or, if path is
+// "stdout", to standard output.
+func (r *Report) Write(path string) error {
+	if path == "stdout" {
+		return r.encode(os.Stdout)
+	}
+	sink, err := NewSink(path)
+	if err != nil {
+		return err
+	}
+	err = r.encode(sink)
+	if cerr := sink.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (r *Report) encode(out io.Writer) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
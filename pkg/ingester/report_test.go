@@ -0,0 +1,113 @@
+package ingester
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mitlibraries/mario/pkg/transformer"
+)
+
+func TestFileReportCount(t *testing.T) {
+	var f FileReport
+	before := map[string]int{"no_title": 2, "parse_error": 1}
+	after := map[string]int{"no_title": 5, "parse_error": 1, "encode_error": 1}
+	f.count(&transformer.Counter{Count: 10, Deleted: 3}, before, after)
+	expected := map[string]int{"no_title": 3, "encode_error": 1}
+	if !reflect.DeepEqual(f.Rejected, expected) {
+		t.Errorf("Expected %v, got %v", expected, f.Rejected)
+	}
+	if f.Read != 13 || f.Indexed != 6 || f.Deleted != 3 {
+		t.Errorf("Expected 13 read, 6 indexed and 3 deleted, got %+v", f)
+	}
+}
+
+func TestReportFinish(t *testing.T) {
+	r := Report{
+		Started: time.Now().UTC().Add(-2 * time.Second),
+		Failed:  2,
+		Files: []FileReport{
+			{Read: 10, Indexed: 8, Deleted: 1, Rejected: map[string]int{"no_title": 1}},
+			{Read: 20, Indexed: 17, Deleted: 0, Rejected: map[string]int{"no_title": 2, "parse_error": 1}},
+		},
+	}
+	r.finish(nil)
+	if r.Read != 30 || r.Indexed != 23 || r.Deleted != 1 {
+		t.Errorf("Expected 30 read, 23 indexed and 1 deleted, got %+v", r)
+	}
+	expected := map[string]int{"no_title": 3, "parse_error": 1}
+	if !reflect.DeepEqual(r.Rejected, expected) {
+		t.Errorf("Expected %v, got %v", expected, r.Rejected)
+	}
+	if r.Seconds < 2 || r.PerSecond <= 0 || r.PerSecond > 15 {
+		t.Error("Unexpected timings", r.Seconds, r.PerSecond)
+	}
+	if !r.Successful || r.Error != "" {
+		t.Error("Expected a successful report, got", r.Error)
+	}
+
+	r = Report{Started: time.Now().UTC()}
+	r.finish(errors.New("boom"))
+	if r.Successful || r.Error != "boom" {
+		t.Error("Expected an unsuccessful report with the error, got", r.Successful, r.Error)
+	}
+}
+
+func TestReportWrite(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "report.json")
+	r := Report{Filename: "aleph.mrc", Index: "aleph-new", Started: time.Now().UTC()}
+	r.Files = []FileReport{{Filename: "aleph.mrc", Read: 1, Indexed: 1}}
+	r.finish(nil)
+	err := r.Write(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written Report
+	err = json.Unmarshal(b, &written)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written.Index != "aleph-new" || written.Indexed != 1 || len(written.Files) != 1 || !written.Successful {
+		t.Errorf("Unexpected report %+v", written)
+	}
+}
+
+func TestIngestCountsAcrossWorkers(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "records.json")
+	var records []string
+	for n := 0; n < 100; n++ {
+		records = append(records, fmt.Sprintf(`{"identifier": "%d", "title": "Microfluids"}`, n))
+	}
+	err := ioutil.WriteFile(file, []byte("["+strings.Join(records, ",")+"]"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := Ingester{Client: &bulkIndexer{}}
+	err = i.Configure(Config{Filename: file, Source: "json", Consumer: "es", Prefix: "aleph", Workers: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := i.Ingest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Read != 100 || report.Indexed != 100 {
+		t.Errorf("Expected 100 read and indexed, got %d and %d", report.Read, report.Indexed)
+	}
+}
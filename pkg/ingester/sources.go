@@ -0,0 +1,209 @@
+package ingester
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/mitlibraries/mario/pkg/client"
+)
+
+// ErrUnknownSource is returned when a file is in the source layout but
+// under a source mario does not know about.
+var ErrUnknownSource = errors.New("Unknown source")
+
+// Source describes how to ingest the files from one source. The source's
+// Name is also used as the prefix for its indexes.
+type Source struct {
+	Name      string
+	Type      string
+	Rulesfile string
+	// Files with Incremental in their name are updates to be added to the
+	// current index rather than full loads into a new one.
+	Incremental string
+}
+
+// IsIncremental reports whether filename is an update for the source's
+// current index.
+func (s Source) IsIncremental(filename string) bool {
+	name := path.Base(filepath.ToSlash(filename))
+	return s.Incremental != "" && strings.Contains(name, s.Incremental)
+}
+
+// Sources are the sources mario knows how to ingest, by name.
+var Sources = map[string]Source{
+	"aleph": {
+		Name:        "aleph",
+		Type:        "marc",
+		Rulesfile:   "/config/marc_rules.json",
+		Incremental: "mit01_edsu1",
+	},
+	"aspace": {
+		Name: "aspace",
+		Type: "archives",
+	},
+	"dspace": {
+		Name: "dspace",
+		Type: "dc",
+	},
+}
+
+// defaultSource is used for files outside the source layout.
+const defaultSource = "aleph"
+
+// typeSources are the sources whose indexes each type of data goes into
+// when it is outside the source layout.
+var typeSources = map[string]string{
+	"marc":     "aleph",
+	"marcxml":  "aleph",
+	"mrk":      "aleph",
+	"marcjson": "aleph",
+	"archives": "aspace",
+	"dc":       "dspace",
+}
+
+// typeSource returns the name of the source for the configured type of
+// data, going by the metadata format for OAI-PMH harvests. It is empty if
+// the type has no source.
+func (c *Config) typeSource() string {
+	switch c.Source {
+	case "":
+		return defaultSource
+	case "oai":
+		return typeSources[oaiFormats[c.Harvest.MetadataPrefix]]
+	}
+	return typeSources[c.Source]
+}
+
+// Environments are the environments used in the source layout.
+var Environments = []string{"prod", "stage"}
+
+// Route is where a file belongs according to the source layout.
+type Route struct {
+	Environment string
+	Source      Source
+}
+
+// Resolve works out the environment and source of a file from its path.
+// Source data is laid out as:
+//
+//	s3://bucket/<environment>/<source>/<files>
+//
+// Local paths are only matched against the layout when they are under
+// root, a local copy of the bucket. A nil Route is returned for paths
+// which do not follow the layout. Paths which do, but under a source that
+// is not in Sources, return ErrUnknownSource along with a Route for the
+// environment and a Source with only the name of the directory.
+func Resolve(filename string, root string) (*Route, error) {
+	parts, err := url.Parse(filename)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	if parts.Scheme == "s3" {
+		dirs = strings.Split(strings.TrimPrefix(parts.Path, "/"), "/")
+	} else if root != "" {
+		rel, err := filepath.Rel(root, filename)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return nil, nil
+		}
+		dirs = strings.Split(filepath.ToSlash(rel), "/")
+		if strings.HasSuffix(filename, string(filepath.Separator)) {
+			dirs = append(dirs, "")
+		}
+	}
+	if len(dirs) < 3 || !isEnvironment(dirs[0]) {
+		return nil, nil
+	}
+	route := &Route{Environment: dirs[0]}
+	source, ok := Sources[dirs[1]]
+	if !ok {
+		route.Source = Source{Name: dirs[1]}
+		return route, fmt.Errorf("%w %q in %s", ErrUnknownSource, dirs[1], filename)
+	}
+	route.Source = source
+	return route, nil
+}
+
+func isEnvironment(name string) bool {
+	for _, env := range Environments {
+		if name == env {
+			return true
+		}
+	}
+	return false
+}
+
+// Route fills in the Source, Prefix and Rulesfile left empty in the
+// config using the source the file belongs to. Files outside the source
+// layout belong to the source named by Prefix, or else to the source for
+// their type of data, which is aleph if no type is given. A Prefix must
+// be given for types of data with no source, such as MODS. Files under an
+// unknown source return ErrUnknownSource and should be skipped, unless
+// the Prefix to use was given.
+func (c *Config) Route() error {
+	route, err := Resolve(c.Filename, c.DataRoot)
+	unknown := errors.Is(err, ErrUnknownSource)
+	if err != nil && (!unknown || c.Prefix == "") {
+		return err
+	}
+	source, ok := Sources[c.Prefix]
+	if route != nil {
+		c.Environment = route.Environment
+		if unknown && !ok {
+			source = Source{Name: c.Prefix}
+		} else if !unknown {
+			source = route.Source
+		}
+	} else if !ok {
+		name := c.typeSource()
+		if name == "" && c.Prefix == "" {
+			return fmt.Errorf("No index prefix for %s data outside the source layout, it must be given", c.Source)
+		}
+		source = Sources[name]
+		if name == "" {
+			// Only the prefix is known, so there are no updates to
+			// look out for.
+			source = Source{Name: c.Prefix}
+		}
+	}
+	if c.Source == "" && source.Type == "" {
+		return fmt.Errorf("No type of data for files from %s, it must be given", source.Name)
+	}
+	if c.Source == "" {
+		c.Source = source.Type
+	}
+	if c.Prefix == "" {
+		c.Prefix = source.Name
+	}
+	if c.Rulesfile == "" {
+		c.Rulesfile = source.Rulesfile
+		if c.Rulesfile == "" && c.typeSource() == defaultSource {
+			c.Rulesfile = Sources[defaultSource].Rulesfile
+		}
+	}
+	if c.Alias == "" {
+		c.Alias = client.EnvironmentAlias(c.Environment)
+	}
+	c.source = source
+	return nil
+}
+
+// incremental reports whether files are updates for the source's current
+// index. Full loads and updates go into different indexes, so it is an
+// error for files to have both.
+func incremental(source Source, files []string) (bool, error) {
+	var updates []string
+	for _, f := range files {
+		if source.IsIncremental(f) {
+			updates = append(updates, f)
+		}
+	}
+	if len(updates) > 0 && len(updates) < len(files) {
+		return false, fmt.Errorf("Found %d incremental updates, such as %s, among %d files: full loads and updates must be ingested separately", len(updates), updates[0], len(files))
+	}
+	return len(updates) > 0, nil
+}
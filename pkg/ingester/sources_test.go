@@ -0,0 +1,244 @@
+package ingester
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	var tests = []struct {
+		filename string
+		root     string
+		env      string
+		source   string
+	}{
+		{"s3://bucket/prod/aleph/mit01_edsu1_20200401.mrc", "", "prod", "aleph"},
+		{"s3://bucket/stage/aspace/aspace.xml", "/data", "stage", "aspace"},
+		{"/data/stage/aleph/full/part1.mrc", "/data", "stage", "aleph"},
+		{"data/prod/aspace/aspace.xml", "data", "prod", "aspace"},
+		{"data/prod/aleph/", "data/", "prod", "aleph"},
+		{"s3://bucket/prod/dspace/theses.xml", "", "prod", "dspace"},
+	}
+	for _, tt := range tests {
+		route, err := Resolve(tt.filename, tt.root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if route == nil {
+			t.Fatal("Expected a route for", tt.filename)
+		}
+		if route.Environment != tt.env || route.Source.Name != tt.source {
+			t.Errorf("Expected %s/%s, got %s/%s", tt.env, tt.source, route.Environment, route.Source.Name)
+		}
+	}
+}
+
+func TestResolveOutsideLayout(t *testing.T) {
+	var tests = []struct {
+		filename string
+		root     string
+	}{
+		{"fixtures/test.mrc", ""},
+		{"s3://bucket/test.mrc", ""},
+		{"s3://bucket/prod/aleph", ""},
+		{"/home/prod/projects/foo/test.mrc", ""},
+		{"/data/prod/aleph/part1.mrc", "/home"},
+		{"/data/prod/aleph", "/data"},
+	}
+	for _, tt := range tests {
+		f := tt.filename
+		route, err := Resolve(f, tt.root)
+		if err != nil {
+			t.Error(err)
+		}
+		if route != nil {
+			t.Error("Expected no route for", f)
+		}
+	}
+}
+
+func TestResolveUnknownSource(t *testing.T) {
+	route, err := Resolve("s3://bucket/prod/unknown/records.xml", "")
+	if !errors.Is(err, ErrUnknownSource) {
+		t.Error("Expected ErrUnknownSource, got", err)
+	}
+	if route == nil || route.Environment != "prod" || route.Source.Name != "unknown" {
+		t.Error("Expected a route for prod/unknown, got", route)
+	}
+}
+
+func TestConfigRouteUnknownSource(t *testing.T) {
+	c := Config{Filename: "s3://bucket/stage/unknown/records.xml"}
+	if err := c.Route(); !errors.Is(err, ErrUnknownSource) {
+		t.Error("Expected ErrUnknownSource, got", err)
+	}
+
+	c = Config{Filename: "s3://bucket/stage/unknown/records.xml", Source: "mods"}
+	if err := c.Route(); !errors.Is(err, ErrUnknownSource) {
+		t.Error("Expected ErrUnknownSource without a prefix, got", err)
+	}
+
+	c = Config{Filename: "s3://bucket/stage/unknown/records.xml", Source: "mods", Prefix: "projects"}
+	if err := c.Route(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Source != "mods" || c.Prefix != "projects" || c.Environment != "stage" || c.Alias != "timdex-stage" {
+		t.Error("Unexpected config", c)
+	}
+
+	c = Config{Filename: "s3://bucket/stage/unknown/records.xml", Prefix: "dspace"}
+	if err := c.Route(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Source != "dc" || c.Prefix != "dspace" || c.Environment != "stage" {
+		t.Error("Unexpected config", c)
+	}
+
+	c = Config{Filename: "s3://bucket/stage/unknown/records.xml", Prefix: "other"}
+	if err := c.Route(); err == nil {
+		t.Error("Expected an error without a type, got", c)
+	}
+}
+
+func TestConfigRoute(t *testing.T) {
+	c := Config{Filename: "s3://bucket/prod/aspace/aspace.xml"}
+	if err := c.Route(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Source != "archives" || c.Prefix != "aspace" || c.Environment != "prod" || c.source.Name != "aspace" {
+		t.Error("Unexpected config", c)
+	}
+
+	c = Config{Filename: "s3://bucket/prod/aleph/mit01_edsu1_20200401.mrc", Prefix: "test"}
+	if err := c.Route(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Source != "marc" || c.Prefix != "test" || c.Rulesfile != "/config/marc_rules.json" || c.source.Name != "aleph" {
+		t.Error("Unexpected config", c)
+	}
+
+	c = Config{Filename: "fixtures/mit01_edsu1_20200401.mrc"}
+	if err := c.Route(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Source != "marc" || c.Prefix != "aleph" || c.source.Name != "aleph" {
+		t.Error("Unexpected config", c)
+	}
+}
+
+func TestIncremental(t *testing.T) {
+	aleph := Sources["aleph"]
+	var tests = []struct {
+		files    []string
+		expected bool
+	}{
+		{[]string{"s3://bucket/prod/aleph/mit01_edsu1_20200401.mrc", "s3://bucket/prod/aleph/mit01_edsu1_20200402.mrc"}, true},
+		{[]string{"s3://bucket/prod/aleph/full/part1.mrc", "s3://bucket/prod/aleph/full/part2.mrc"}, false},
+		{[]string{"data/mit01_edsu1/part1.mrc"}, false},
+	}
+	for _, tt := range tests {
+		inc, err := incremental(aleph, tt.files)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if inc != tt.expected {
+			t.Errorf("Expected %v for %v, got %v", tt.expected, tt.files, inc)
+		}
+	}
+
+	_, err := incremental(aleph, []string{"s3://bucket/prod/aleph/mit01_edsu1_20200401.mrc", "s3://bucket/prod/aleph/part1.mrc"})
+	if err == nil {
+		t.Error("Expected an error for full loads mixed with updates")
+	}
+	inc, err := incremental(Sources["aspace"], []string{"mit01_edsu1_20200401.xml"})
+	if err != nil || inc {
+		t.Error("Expected aspace files never to be incremental, got", inc, err)
+	}
+}
+
+func TestConfigRouteByType(t *testing.T) {
+	var tests = []struct {
+		source   string
+		format   string
+		expected string
+	}{
+		{"", "", "aleph"},
+		{"marcxml", "", "aleph"},
+		{"archives", "", "aspace"},
+		{"dc", "", "dspace"},
+		{"oai", "oai_ead", "aspace"},
+		{"oai", "oai_dc", "dspace"},
+		{"oai", "qdc", "dspace"},
+		{"oai", "marc21", "aleph"},
+	}
+	for _, tt := range tests {
+		c := Config{Filename: "https://example.mit.edu/oai", Source: tt.source}
+		c.Harvest.MetadataPrefix = tt.format
+		if err := c.Route(); err != nil {
+			t.Fatal(err)
+		}
+		if c.Prefix != tt.expected {
+			t.Errorf("Expected %s for %s %s, got %s", tt.expected, tt.source, tt.format, c.Prefix)
+		}
+	}
+
+	for _, source := range []string{"mods", "json"} {
+		c := Config{Filename: "records.xml", Source: source}
+		if err := c.Route(); err == nil {
+			t.Errorf("Expected an error for %s without a prefix, got %s", source, c.Prefix)
+		}
+		c = Config{Filename: "records.xml", Source: source, Prefix: "test"}
+		if err := c.Route(); err != nil || c.Prefix != "test" {
+			t.Errorf("Expected %s with a prefix to be routed, got %s %v", source, c.Prefix, err)
+		}
+	}
+	c := Config{Filename: "https://example.mit.edu/oai", Source: "oai"}
+	c.Harvest.MetadataPrefix = "mods"
+	if err := c.Route(); err == nil {
+		t.Error("Expected an error for an OAI-PMH format without a source, got", c.Prefix)
+	}
+}
+
+func TestConfigRouteCustomPrefix(t *testing.T) {
+	c := Config{Filename: "data/mit01_edsu1.xml", Source: "mods", Prefix: "foo"}
+	if err := c.Route(); err != nil {
+		t.Fatal(err)
+	}
+	if c.source.Name != "foo" || c.Rulesfile != "" {
+		t.Error("Expected a source of its own for foo, got", c.source, c.Rulesfile)
+	}
+	inc, err := incremental(c.source, []string{c.Filename})
+	if err != nil || inc {
+		t.Error("Expected mit01_edsu1.xml not to be an update for foo, got", inc, err)
+	}
+
+	c = Config{Filename: "data/records.xml", Source: "marcxml", Prefix: "foo"}
+	if err := c.Route(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Rulesfile != "/config/marc_rules.json" {
+		t.Error("Expected MARC data to use the aleph rules, got", c.Rulesfile)
+	}
+}
+
+func TestConfigRouteAlias(t *testing.T) {
+	var tests = []struct {
+		filename string
+		alias    string
+		expected string
+	}{
+		{"s3://bucket/stage/aleph/mit01_edsu1_20200401.mrc", "", "timdex-stage"},
+		{"s3://bucket/prod/aspace/aspace.xml", "", "timdex-prod"},
+		{"fixtures/test.mrc", "", "timdex-prod"},
+		{"s3://bucket/stage/aleph/mit01_edsu1_20200401.mrc", "timdex-test", "timdex-test"},
+	}
+	for _, tt := range tests {
+		c := Config{Filename: tt.filename, Alias: tt.alias}
+		if err := c.Route(); err != nil {
+			t.Fatal(err)
+		}
+		if c.Alias != tt.expected {
+			t.Errorf("Expected alias %s for %s, got %s", tt.expected, tt.filename, c.Alias)
+		}
+	}
+}
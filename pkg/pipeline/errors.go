@@ -0,0 +1,76 @@
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+)
+
+//Reasons a record can be rejected. These are meant to be machine
+//readable and are written alongside rejected records.
+const (
+	ReasonParse        = "parse_error"
+	ReasonStatus       = "illegal_status"
+	ReasonNoIdentifier = "missing_identifier"
+	ReasonNoTitle      = "missing_title"
+	ReasonIdentifier   = "invalid_identifier"
+	ReasonEncode       = "encode_error"
+)
+
+//RecordError reports a single Record that could not be processed. A
+//RecordError does not stop a Pipeline unless the Pipeline is set to fail
+//fast. Any other error sent by a stage is treated as fatal.
+//
+//Reason is one of the Reason constants. Raw holds the record as it
+//appeared in the input, when the stage that rejected it has it, so that
+//it can be fixed and ingested again.
+type RecordError struct {
+	Identifier string
+	Reason     string
+	Raw        []byte
+	Err        error
+}
+
+//A Rejecter receives every RecordError reported while a Pipeline runs.
+type Rejecter interface {
+	Reject(*RecordError) error
+}
+
+func (e *RecordError) Error() string {
+	return e.Err.Error()
+}
+
+//Unwrap returns the underlying error.
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+//Errors aggregates the errors reported while running a Pipeline. Fatal
+//is set when a stage could not continue, for example because its
+//configuration could not be loaded or the input stream was unreadable.
+//Rejected counts the RecordErrors by reason. The RecordErrors themselves
+//are not kept, as a bad file can have a great many, so a Pipeline's
+//Rejects should be used to see them.
+type Errors struct {
+	Fatal    error
+	Rejected map[string]int
+}
+
+//Total returns the number of RecordErrors reported.
+func (e *Errors) Total() int {
+	var n int
+	for _, c := range e.Rejected {
+		n += c
+	}
+	return n
+}
+
+func (e *Errors) Error() string {
+	var msgs []string
+	if e.Fatal != nil {
+		msgs = append(msgs, e.Fatal.Error())
+	}
+	if n := e.Total(); n > 0 {
+		msgs = append(msgs, fmt.Sprintf("%d records could not be processed", n))
+	}
+	return strings.Join(msgs, "; ")
+}
@@ -1,32 +1,57 @@
 package pipeline
 
-import "github.com/mitlibraries/mario/pkg/record"
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mitlibraries/mario/pkg/record"
+)
 
 //A Pipeline builds and runs a data pipeline for process Records. A
 //Pipeline consists of exactly one Generator, one Consumer and zero or
 //more Transformers.
+//
+//Cancelling the context passed to Run stops the Generator, which closes
+//its channel. Transformers and Consumers keep reading until their input
+//channel is closed so that Records already in flight are drained rather
+//than dropped.
+//
+//Errors are sent by every stage on the channel it is given. A fatal
+//error stops the Pipeline. A RecordError only stops the Pipeline when
+//FailFast is set. If Rejects is set, every RecordError is handed to it;
+//failing to write a rejected record is fatal. Only the number of
+//RecordErrors for each reason is kept once they have been handed on.
 type Pipeline struct {
 	Generator    Generator
 	Transformers []Transformer
 	Consumer     Consumer
+	FailFast     bool
+	Rejects      Rejecter
 }
 
 //The Transformer interface can be used to create an intermediate stage
-//in a Pipeline.
+//in a Pipeline. Problems are reported on the error channel, which must
+//not be sent on after the returned channel is closed.
 type Transformer interface {
-	Transform(<-chan record.Record) <-chan record.Record
+	Transform(context.Context, <-chan record.Record, chan<- error) <-chan record.Record
 }
 
 //The Generator interface should be used to create the initial stage of
-//a Pipeline.
+//a Pipeline. A Generator should stop sending Records and close its
+//channel once the context is done. Problems are reported on the error
+//channel, which must not be sent on after the Record channel is closed.
 type Generator interface {
-	Generate() <-chan record.Record
+	Generate(context.Context, chan<- error) <-chan record.Record
 }
 
 //The Consumer interface should be used to create the last stage of a
-//Pipeline.
+//Pipeline. A Consumer should keep reading until its input channel is
+//closed, even after a fatal error. Problems are reported on the error
+//channel, which must not be sent on after the returned channel is
+//closed.
 type Consumer interface {
-	Consume(<-chan record.Record) <-chan bool
+	Consume(context.Context, <-chan record.Record, chan<- error) <-chan bool
 }
 
 //Next adds one or more Transformers to the Pipeline. Next can be called
@@ -35,12 +60,49 @@ func (p *Pipeline) Next(t ...Transformer) {
 	p.Transformers = append(p.Transformers, t...)
 }
 
-//Run the Pipeline. Be sure to read from the empty channel that's returned
-//as that signals the Pipeline has finished running.
-func (p *Pipeline) Run() <-chan bool {
-	out := p.Generator.Generate()
+//Run the Pipeline and wait for it to finish. If any errors were reported
+//the returned error will be an *Errors.
+func (p *Pipeline) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error)
+	result := &Errors{Rejected: make(map[string]int)}
+	collected := make(chan bool)
+	go func() {
+		for err := range errs {
+			var re *RecordError
+			if errors.As(err, &re) {
+				result.Rejected[re.Reason]++
+				if p.Rejects != nil {
+					if rerr := p.Rejects.Reject(re); rerr != nil && result.Fatal == nil {
+						result.Fatal = fmt.Errorf("Could not write rejected record: %w", rerr)
+						cancel()
+					}
+				}
+				if p.FailFast {
+					cancel()
+				}
+			} else {
+				if result.Fatal == nil {
+					result.Fatal = err
+				}
+				cancel()
+			}
+		}
+		close(collected)
+	}()
+
+	out := p.Generator.Generate(ctx, errs)
 	for _, t := range p.Transformers {
-		out = t.Transform(out)
+		out = t.Transform(ctx, out, errs)
+	}
+	<-p.Consumer.Consume(ctx, out, errs)
+	close(errs)
+	<-collected
+
+	if result.Fatal == nil && result.Total() == 0 {
+		return nil
 	}
-	return p.Consumer.Consume(out)
+	return result
 }
@@ -1,13 +1,17 @@
 package pipeline
 
 import (
-	"github.com/mitlibraries/mario/pkg/record"
+	"context"
+	"errors"
+	"strconv"
 	"testing"
+
+	"github.com/mitlibraries/mario/pkg/record"
 )
 
 type Fooer struct{}
 
-func (f *Fooer) Transform(in <-chan record.Record) <-chan record.Record {
+func (f *Fooer) Transform(ctx context.Context, in <-chan record.Record, errs chan<- error) <-chan record.Record {
 	out := make(chan record.Record)
 	go func() {
 		for r := range in {
@@ -21,7 +25,7 @@ func (f *Fooer) Transform(in <-chan record.Record) <-chan record.Record {
 
 type RecordGenerator struct{}
 
-func (g *RecordGenerator) Generate() <-chan record.Record {
+func (g *RecordGenerator) Generate(ctx context.Context, errs chan<- error) <-chan record.Record {
 	out := make(chan record.Record)
 	go func() {
 		out <- record.Record{Title: "Bar"}
@@ -31,11 +35,47 @@ func (g *RecordGenerator) Generate() <-chan record.Record {
 	return out
 }
 
+type EndlessGenerator struct{}
+
+func (g *EndlessGenerator) Generate(ctx context.Context, errs chan<- error) <-chan record.Record {
+	out := make(chan record.Record)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case out <- record.Record{Title: "Bar"}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+type ErrorGenerator struct {
+	errs []error
+}
+
+func (g *ErrorGenerator) Generate(ctx context.Context, errs chan<- error) <-chan record.Record {
+	out := make(chan record.Record)
+	go func() {
+		defer close(out)
+		for _, err := range g.errs {
+			errs <- err
+			if _, ok := err.(*RecordError); !ok || ctx.Err() != nil {
+				return
+			}
+			out <- record.Record{Title: "Bar"}
+		}
+	}()
+	return out
+}
+
 type RecordConsumer struct {
 	records []record.Record
 }
 
-func (c *RecordConsumer) Consume(in <-chan record.Record) <-chan bool {
+func (c *RecordConsumer) Consume(ctx context.Context, in <-chan record.Record, errs chan<- error) <-chan bool {
 	out := make(chan bool)
 	go func() {
 		for r := range in {
@@ -53,9 +93,224 @@ func TestRun(t *testing.T) {
 		Consumer:  c,
 	}
 	p.Next(&Fooer{})
-	out := p.Run()
-	<-out
+	err := p.Run(context.Background())
+	if err != nil {
+		t.Error("Expected nil, got", err)
+	}
 	if c.records[0].Title != "BarFOO" {
 		t.Error("Expected match, got", c.records[0].Title)
 	}
 }
+
+func TestRunCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c := &RecordConsumer{}
+	p := Pipeline{
+		Generator: &EndlessGenerator{},
+		Consumer:  c,
+	}
+	p.Next(&Fooer{})
+	err := p.Run(ctx)
+	if err != nil {
+		t.Error("Expected nil, got", err)
+	}
+	for _, r := range c.records {
+		if r.Title != "BarFOO" {
+			t.Error("Expected match, got", r.Title)
+		}
+	}
+}
+
+func TestRunRecordErrors(t *testing.T) {
+	c := &RecordConsumer{}
+	g := &ErrorGenerator{errs: []error{
+		&RecordError{Identifier: "1", Err: errors.New("bad")},
+		&RecordError{Identifier: "2", Err: errors.New("worse")},
+	}}
+	p := Pipeline{Generator: g, Consumer: c}
+	err := p.Run(context.Background())
+	var perr *Errors
+	if !errors.As(err, &perr) {
+		t.Fatal("Expected *Errors, got", err)
+	}
+	if perr.Fatal != nil {
+		t.Error("Expected nil, got", perr.Fatal)
+	}
+	if perr.Total() != 2 {
+		t.Error("Expected 2, got", perr.Total())
+	}
+	if len(c.records) != 2 {
+		t.Error("Expected 2, got", len(c.records))
+	}
+}
+
+type sliceRejecter struct {
+	rejected []*RecordError
+}
+
+func (r *sliceRejecter) Reject(e *RecordError) error {
+	r.rejected = append(r.rejected, e)
+	return nil
+}
+
+func TestRunCountsRejectsByReason(t *testing.T) {
+	rejects := &sliceRejecter{}
+	g := &ErrorGenerator{errs: []error{
+		&RecordError{Identifier: "1", Reason: ReasonNoTitle, Raw: []byte("1"), Err: errors.New("bad")},
+		&RecordError{Identifier: "2", Reason: ReasonParse, Raw: []byte("2"), Err: errors.New("worse")},
+		&RecordError{Identifier: "3", Reason: ReasonNoTitle, Raw: []byte("3"), Err: errors.New("worst")},
+	}}
+	p := Pipeline{Generator: g, Consumer: &RecordConsumer{}, Rejects: rejects}
+	err := p.Run(context.Background())
+	var perr *Errors
+	if !errors.As(err, &perr) {
+		t.Fatal("Expected *Errors, got", err)
+	}
+	if perr.Rejected[ReasonNoTitle] != 2 || perr.Rejected[ReasonParse] != 1 {
+		t.Error("Unexpected counts", perr.Rejected)
+	}
+	if len(rejects.rejected) != 3 || string(rejects.rejected[2].Raw) != "3" {
+		t.Error("Expected every rejected record to be handed on, got", rejects.rejected)
+	}
+}
+
+func TestRunFailFast(t *testing.T) {
+	c := &RecordConsumer{}
+	g := &ErrorGenerator{errs: []error{
+		&RecordError{Identifier: "1", Err: errors.New("bad")},
+		&RecordError{Identifier: "2", Err: errors.New("worse")},
+		&RecordError{Identifier: "3", Err: errors.New("worst")},
+	}}
+	p := Pipeline{Generator: g, Consumer: c, FailFast: true}
+	err := p.Run(context.Background())
+	var perr *Errors
+	if !errors.As(err, &perr) {
+		t.Fatal("Expected *Errors, got", err)
+	}
+	if perr.Total() == 3 {
+		t.Error("Expected pipeline to stop early, got", perr.Total())
+	}
+}
+
+func TestRunFatal(t *testing.T) {
+	c := &RecordConsumer{}
+	g := &ErrorGenerator{errs: []error{errors.New("no rules")}}
+	p := Pipeline{Generator: g, Consumer: c}
+	err := p.Run(context.Background())
+	var perr *Errors
+	if !errors.As(err, &perr) {
+		t.Fatal("Expected *Errors, got", err)
+	}
+	if perr.Fatal == nil || perr.Fatal.Error() != "no rules" {
+		t.Error("Expected match, got", perr.Fatal)
+	}
+	if len(c.records) != 0 {
+		t.Error("Expected 0, got", len(c.records))
+	}
+}
+
+type FailingConsumer struct {
+	errs []error
+}
+
+func (c *FailingConsumer) Consume(ctx context.Context, in <-chan record.Record, errs chan<- error) <-chan bool {
+	out := make(chan bool)
+	go func() {
+		for range in {
+			if len(c.errs) > 0 {
+				errs <- c.errs[0]
+				c.errs = c.errs[1:]
+			}
+		}
+		close(out)
+	}()
+	return out
+}
+
+func TestRunConsumerErrors(t *testing.T) {
+	c := &FailingConsumer{errs: []error{
+		&RecordError{Identifier: "1", Err: errors.New("bad")},
+		errors.New("disk full"),
+	}}
+	p := Pipeline{Generator: &RecordGenerator{}, Consumer: c}
+	err := p.Run(context.Background())
+	var perr *Errors
+	if !errors.As(err, &perr) {
+		t.Fatal("Expected *Errors, got", err)
+	}
+	if perr.Fatal == nil || perr.Fatal.Error() != "disk full" {
+		t.Error("Expected the consumer's fatal error, got", perr.Fatal)
+	}
+	if perr.Total() != 1 {
+		t.Error("Expected 1, got", perr.Total())
+	}
+}
+
+type Doubler struct{}
+
+func (d *Doubler) Transform(ctx context.Context, in <-chan record.Record, errs chan<- error) <-chan record.Record {
+	out := make(chan record.Record)
+	go func() {
+		for r := range in {
+			out <- r
+			out <- r
+		}
+		close(out)
+	}()
+	return out
+}
+
+type SliceGenerator struct {
+	records []record.Record
+}
+
+func (g *SliceGenerator) Generate(ctx context.Context, errs chan<- error) <-chan record.Record {
+	out := make(chan record.Record)
+	go func() {
+		for _, r := range g.records {
+			out <- r
+		}
+		close(out)
+	}()
+	return out
+}
+
+func TestPoolOrdered(t *testing.T) {
+	var records []record.Record
+	for i := 0; i < 100; i++ {
+		records = append(records, record.Record{Identifier: strconv.Itoa(i)})
+	}
+	c := &RecordConsumer{}
+	p := Pipeline{Generator: &SliceGenerator{records: records}, Consumer: c}
+	p.Next(&Pool{Transformer: &Doubler{}, Workers: 8, Ordered: true})
+	err := p.Run(context.Background())
+	if err != nil {
+		t.Error("Expected nil, got", err)
+	}
+	if len(c.records) != 200 {
+		t.Fatal("Expected 200, got", len(c.records))
+	}
+	for i, r := range c.records {
+		if r.Identifier != strconv.Itoa(i/2) {
+			t.Errorf("Expected %d, got %s", i/2, r.Identifier)
+		}
+	}
+}
+
+func TestPoolUnordered(t *testing.T) {
+	var records []record.Record
+	for i := 0; i < 100; i++ {
+		records = append(records, record.Record{Identifier: strconv.Itoa(i)})
+	}
+	c := &RecordConsumer{}
+	p := Pipeline{Generator: &SliceGenerator{records: records}, Consumer: c}
+	p.Next(&Pool{Transformer: &Doubler{}, Workers: 8})
+	err := p.Run(context.Background())
+	if err != nil {
+		t.Error("Expected nil, got", err)
+	}
+	if len(c.records) != 200 {
+		t.Error("Expected 200, got", len(c.records))
+	}
+}
@@ -0,0 +1,113 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mitlibraries/mario/pkg/record"
+)
+
+//A Task produces zero or more Records. Tasks are run by RunTasks.
+type Task func() []record.Record
+
+//RunTasks runs the Tasks received on in across a number of workers and
+//sends the Records they produce on the returned channel. The channel is
+//closed once in has been closed and every Task has finished. If ordered
+//is true Records are sent in the order their Tasks were received,
+//otherwise they are sent as soon as each Task finishes.
+func RunTasks(in <-chan Task, workers int, ordered bool) <-chan record.Record {
+	if workers < 1 {
+		workers = 1
+	}
+	if ordered {
+		return runOrdered(in, workers)
+	}
+	return runUnordered(in, workers)
+}
+
+func runUnordered(in <-chan Task, workers int) <-chan record.Record {
+	out := make(chan record.Record)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for t := range in {
+				for _, r := range t() {
+					out <- r
+				}
+			}
+			wg.Done()
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+func runOrdered(in <-chan Task, workers int) <-chan record.Record {
+	out := make(chan record.Record)
+	// Each Task gets its own result channel. Result channels are queued in
+	// the order their Tasks arrived and read back in that order, while the
+	// semaphore limits how many Tasks run at once.
+	results := make(chan chan []record.Record, workers)
+	sem := make(chan bool, workers)
+	go func() {
+		for t := range in {
+			sem <- true
+			res := make(chan []record.Record, 1)
+			results <- res
+			go func(t Task, res chan<- []record.Record) {
+				res <- t()
+				<-sem
+			}(t, res)
+		}
+		close(results)
+	}()
+	go func() {
+		for res := range results {
+			for _, r := range <-res {
+				out <- r
+			}
+		}
+		close(out)
+	}()
+	return out
+}
+
+//Pool runs a Transformer across a number of workers. Each Record is
+//handed to the Transformer on its own, so the Transformer must be safe
+//for concurrent use. If Ordered is set the Pool sends Records in the
+//order it received them.
+type Pool struct {
+	Transformer Transformer
+	Workers     int
+	Ordered     bool
+}
+
+//Transform the Records using the pool of workers.
+func (p *Pool) Transform(ctx context.Context, in <-chan record.Record, errs chan<- error) <-chan record.Record {
+	tasks := make(chan Task)
+	go func() {
+		for r := range in {
+			r := r
+			tasks <- func() []record.Record {
+				return transformOne(ctx, p.Transformer, r, errs)
+			}
+		}
+		close(tasks)
+	}()
+	return RunTasks(tasks, p.Workers, p.Ordered)
+}
+
+func transformOne(ctx context.Context, t Transformer, r record.Record, errs chan<- error) []record.Record {
+	in := make(chan record.Record, 1)
+	in <- r
+	close(in)
+	var rs []record.Record
+	for r := range t.Transform(ctx, in, errs) {
+		rs = append(rs, r)
+	}
+	return rs
+}
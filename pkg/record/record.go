@@ -36,6 +36,10 @@ type Record struct {
 	Links                []Link         `json:"links,omitempty"`
 	Holdings             []Holding      `json:"holdings,omitempty"`
 	Citation             string         `json:"citation,omitempty"`
+	// Deleted marks a Record which has been withdrawn at its source. Only
+	// the Identifier is set, and consumers should remove the Record
+	// rather than add it.
+	Deleted bool `json:"-"`
 }
 
 // Contributor is a port of a Record
@@ -1,18 +1,32 @@
 package transformer
 
-import "github.com/mitlibraries/mario/pkg/record"
+import (
+	"context"
+	"sync"
 
-//Counter transformer records the number of records handled.
+	"github.com/mitlibraries/mario/pkg/record"
+)
+
+//Counter transformer records the number of records handled, and how
+//many of those were deleted records. It is safe to run a Counter across a
+//pool of workers.
 type Counter struct {
-	Count int
+	Count   int
+	Deleted int
+	mu      sync.Mutex
 }
 
 //Transform counts the records.
-func (c *Counter) Transform(in <-chan record.Record) <-chan record.Record {
+func (c *Counter) Transform(ctx context.Context, in <-chan record.Record, errs chan<- error) <-chan record.Record {
 	out := make(chan record.Record)
 	go func() {
 		for r := range in {
+			c.mu.Lock()
 			c.Count++
+			if r.Deleted {
+				c.Deleted++
+			}
+			c.mu.Unlock()
 			out <- r
 		}
 		close(out)
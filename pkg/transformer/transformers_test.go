@@ -1,6 +1,8 @@
 package transformer
 
 import (
+	"context"
+	"github.com/mitlibraries/mario/pkg/pipeline"
 	"github.com/mitlibraries/mario/pkg/record"
 	"testing"
 )
@@ -11,9 +13,26 @@ func TestCounterTransform(t *testing.T) {
 	in <- record.Record{Title: "Bar"}
 	close(in)
 	c := Counter{}
-	out := c.Transform(in)
+	out := c.Transform(context.Background(), in, nil)
 	<-out
 	if c.Count != 2 {
 		t.Error("Expected match, got", c.Count)
 	}
 }
+
+func TestCounterPool(t *testing.T) {
+	in := make(chan record.Record)
+	go func() {
+		for i := 0; i < 1000; i++ {
+			in <- record.Record{Title: "Foo"}
+		}
+		close(in)
+	}()
+	c := &Counter{}
+	p := &pipeline.Pool{Transformer: c, Workers: 8}
+	for range p.Transform(context.Background(), in, nil) {
+	}
+	if c.Count != 1000 {
+		t.Error("Expected match, got", c.Count)
+	}
+}